@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen — агент подряд не отвечает, вызовы временно не выполняются
+var ErrCircuitOpen = errors.New("AI agent is temporarily unavailable (circuit open)")
+
+// breaker — автомат отключения: после threshold неудачных вызовов подряд вызовы отклоняются
+// на cooldown, затем пропускается один пробный вызов. Успех замыкает цепь, неудача — снова размыкает.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int       // Неудачных вызовов подряд
+	openUntil time.Time // До какого момента вызовы отклоняются
+	probing   bool      // Пробный вызов уже выполняется
+}
+
+// WithCircuitBreaker — размыкать цепь после threshold временных сбоев подряд на cooldown (threshold 0 — отключить)
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *Client) {
+		if threshold <= 0 {
+			c.breaker = nil
+			return
+		}
+		c.breaker = &breaker{threshold: threshold, cooldown: cooldown}
+	}
+}
+
+// allow — можно ли выполнить вызов
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// record — учесть результат вызова (ошибки, не связанные с доступностью агента, цепь не размыкают)
+func (b *breaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err == nil || !isTransient(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// abandon — вызов прерван самим вызывающим (отмена контекста): результат не учитывается
+func (b *breaker) abandon() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	transient := &NetworkError{Endpoint: "/x", Err: errors.New("timeout")}
+	permanent := &StatusError{Endpoint: "/x", StatusCode: 400}
+
+	// Шаги: call — вызов с результатом err (wantAllow — пропустит ли его автомат),
+	// expire — истёк cooldown, abandon — вызов отменён вызывающим.
+	type step struct {
+		action    string
+		err       error
+		wantAllow bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"closed below threshold", []step{
+			{"call", transient, true},
+			{"call", transient, true},
+			{"call", nil, true},
+		}},
+		{"opens after threshold failures in a row", []step{
+			{"call", transient, true},
+			{"call", transient, true},
+			{"call", transient, true},
+			{"call", nil, false},
+		}},
+		{"success resets the count", []step{
+			{"call", transient, true},
+			{"call", transient, true},
+			{"call", nil, true},
+			{"call", transient, true},
+			{"call", transient, true},
+			{"call", nil, true},
+		}},
+		{"permanent errors do not open", []step{
+			{"call", permanent, true},
+			{"call", permanent, true},
+			{"call", permanent, true},
+			{"call", nil, true},
+		}},
+		{"half-open probe success closes", []step{
+			{"call", transient, true},
+			{"call", transient, true},
+			{"call", transient, true},
+			{"expire", nil, false},
+			{"call", nil, true},
+			{"call", nil, true},
+		}},
+		{"half-open probe failure reopens", []step{
+			{"call", transient, true},
+			{"call", transient, true},
+			{"call", transient, true},
+			{"expire", nil, false},
+			{"call", transient, true},
+			{"call", nil, false},
+		}},
+		{"only one probe at a time", []step{
+			{"call", transient, true},
+			{"call", transient, true},
+			{"call", transient, true},
+			{"expire", nil, false},
+			{"probe", nil, true},
+			{"call", nil, false},
+			{"abandon", nil, false},
+			{"call", nil, true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &breaker{threshold: 3, cooldown: time.Minute}
+			for i, s := range tt.steps {
+				switch s.action {
+				case "expire":
+					b.openUntil = time.Now().Add(-time.Second)
+				case "abandon":
+					b.abandon()
+				case "probe", "call":
+					err := b.allow()
+					if allowed := err == nil; allowed != s.wantAllow {
+						t.Fatalf("step %d: allow() = %v, want allowed %v", i, err, s.wantAllow)
+					}
+					if err != nil && !errors.Is(err, ErrCircuitOpen) {
+						t.Fatalf("step %d: allow() = %v, want %v", i, err, ErrCircuitOpen)
+					}
+					if err == nil && s.action == "call" {
+						b.record(s.err)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("nil breaker always allows", func(t *testing.T) {
+		var b *breaker
+		if err := b.allow(); err != nil {
+			t.Errorf("allow() = %v, want nil", err)
+		}
+		b.record(transient)
+		b.abandon()
+	})
+}
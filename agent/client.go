@@ -0,0 +1,295 @@
+// Package agent — типизированный клиент протокола бот → AI агент (см. AI_AGENT_FORMAT.md)
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout     = 60 * time.Second // Генерация текста и картинок может идти долго
+	defaultInitTimeout = 10 * time.Second // Инициализация пользователя должна быть быстрой
+	errorBodyLimit     = 1024             // Сколько байт тела ответа с ошибкой включать в StatusError
+
+	defaultPayloadLogLimit = 2048 // Сколько байт тела запроса/ответа писать в лог
+
+	defaultBreakerThreshold = 5                // Временных сбоев подряд до размыкания цепи
+	defaultBreakerCooldown  = 30 * time.Second // Сколько не обращаться к агенту после размыкания
+)
+
+// Client — клиент AI агента; один экземпляр на всё приложение (безопасен для параллельного использования)
+type Client struct {
+	baseURL     string
+	httpClient  *http.Client
+	timeout     time.Duration
+	initTimeout time.Duration
+	logger      *slog.Logger
+	retry       RetryPolicy
+	breaker     *breaker
+	observe     func(endpoint string, err error, elapsed time.Duration)
+
+	payloadLogLimit int // Сколько байт тела писать в лог (0 — без ограничения)
+
+	secret          []byte // Общий секрет для подписи запросов (пустой — не подписывать)
+	verifyResponses bool   // Требовать подпись ответов агента
+}
+
+// Option — настройка клиента
+type Option func(*Client)
+
+// WithHTTPClient — свой http.Client (транспорт, прокси и т.п.)
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithTimeouts — таймауты запросов: генерации и инициализации пользователя
+func WithTimeouts(timeout, initTimeout time.Duration) Option {
+	return func(c *Client) {
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+		if initTimeout > 0 {
+			c.initTimeout = initTimeout
+		}
+	}
+}
+
+// WithLogger — куда писать лог запросов (по умолчанию slog.Default())
+// Тела запросов и ответов пишутся на уровне Debug, повторы — на уровне Warn.
+// Чтобы не писать ничего, передай slog.New(slog.DiscardHandler).
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) { c.logger = logger }
+}
+
+// WithPayloadLogLimit — сколько байт тела запроса/ответа писать в лог (0 — без ограничения)
+// Тела всегда проходят через Redact: секреты и base64-данные в лог не попадают.
+func WithPayloadLogLimit(limit int) Option {
+	return func(c *Client) { c.payloadLogLimit = limit }
+}
+
+// WithObserver — вызывать observe после каждой попытки запроса (для метрик задержки)
+// err — результат попытки: nil, *StatusError, *NetworkError, *SignatureError или *DecodeError.
+func WithObserver(observe func(endpoint string, err error, elapsed time.Duration)) Option {
+	return func(c *Client) { c.observe = observe }
+}
+
+// NewClient — клиент для агента по адресу baseURL (AI_AGENT_URL)
+// Пустой адрес допустим: каждый вызов вернёт ErrNotConfigured.
+// По умолчанию временные сбои повторяются (DefaultRetryPolicy), а после 5 неудачных вызовов подряд
+// агент считается недоступным на 30 секунд (ErrCircuitOpen).
+func NewClient(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		httpClient:  &http.Client{},
+		timeout:     defaultTimeout,
+		initTimeout: defaultInitTimeout,
+		retry:       DefaultRetryPolicy,
+		breaker:     &breaker{threshold: defaultBreakerThreshold, cooldown: defaultBreakerCooldown},
+
+		payloadLogLimit: defaultPayloadLogLimit,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// InitUser — /api/auth/init (данные отправляются напрямую, без обёртки)
+func (c *Client) InitUser(ctx context.Context, req InitUserRequest) (*InitUserResponse, error) {
+	var resp InitUserResponse
+	if err := c.post(ctx, "/api/auth/init", req, &resp, c.initTimeout); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GenerateText — /generate_text
+func (c *Client) GenerateText(ctx context.Context, tgID int64, req GenerateTextRequest) (*Post, error) {
+	var post Post
+	if err := c.call(ctx, "/generate_text", tgID, req, &post); err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// GenerateImage — /generate_image
+func (c *Client) GenerateImage(ctx context.Context, tgID int64, req GenerateImageRequest) (*Post, error) {
+	var post Post
+	if err := c.call(ctx, "/generate_image", tgID, req, &post); err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// EditText — /edit_text
+func (c *Client) EditText(ctx context.Context, tgID int64, req EditTextRequest) (*EditTextResponse, error) {
+	var resp EditTextResponse
+	if err := c.call(ctx, "/edit_text", tgID, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ContentPlan — /content_plan
+func (c *Client) ContentPlan(ctx context.Context, tgID int64, req ContentPlanRequest) (*ContentPlanResponse, error) {
+	var resp ContentPlanResponse
+	if err := c.call(ctx, "/content_plan", tgID, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SendPost — /send_post
+func (c *Client) SendPost(ctx context.Context, tgID int64, req SendPostRequest) (*SendPostResponse, error) {
+	var resp SendPostResponse
+	if err := c.call(ctx, "/send_post", tgID, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RegeneratePost — /regenerate_post
+func (c *Client) RegeneratePost(ctx context.Context, tgID int64, req RegeneratePostRequest) (*Post, error) {
+	var post Post
+	if err := c.call(ctx, "/regenerate_post", tgID, req, &post); err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// call — запрос в обёртке Envelope
+func (c *Client) call(ctx context.Context, endpoint string, tgID int64, data interface{}, out interface{}) error {
+	envelope := Envelope{
+		Endpoint:       endpoint,
+		Data:           data,
+		TgID:           tgID,
+		Timestamp:      time.Now().Unix(),
+		IdempotencyKey: newIdempotencyKey(),
+		RequestID:      RequestID(ctx),
+	}
+	return c.post(ctx, endpoint, envelope, out, c.timeout)
+}
+
+// post — отправляет JSON на baseURL+endpoint и разбирает ответ в out
+// Временные сбои повторяются с тем же телом и тем же ключом идемпотентности.
+func (c *Client) post(ctx context.Context, endpoint string, payload interface{}, out interface{}, timeout time.Duration) error {
+	if c.baseURL == "" {
+		return ErrNotConfigured
+	}
+	if err := c.breaker.allow(); err != nil {
+		return err
+	}
+
+	idempotencyKey := newIdempotencyKey()
+	if envelope, ok := payload.(Envelope); ok {
+		idempotencyKey = envelope.IdempotencyKey
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		c.breaker.abandon()
+		return err
+	}
+	logger := c.log().With("endpoint", endpoint)
+	if id := RequestID(ctx); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	if logger.Enabled(ctx, slog.LevelDebug) {
+		logger.DebugContext(ctx, "Sending to AI agent", "payload", c.logPayload(body))
+	}
+
+	for attempt := 1; ; attempt++ {
+		started := time.Now()
+		err = c.attempt(ctx, logger, endpoint, body, idempotencyKey, out, timeout)
+		if c.observe != nil {
+			c.observe(endpoint, err, time.Since(started))
+		}
+		if err == nil || !isTransient(err) || attempt >= c.retry.MaxAttempts || ctx.Err() != nil {
+			break
+		}
+		delay := c.retry.delay(attempt)
+		logger.WarnContext(ctx, "AI agent call failed, retrying",
+			"attempt", attempt, "max_attempts", c.retry.MaxAttempts, "delay", delay.Round(time.Millisecond), "err", err)
+		if sleep(ctx, delay) != nil {
+			break
+		}
+	}
+
+	if ctx.Err() != nil {
+		c.breaker.abandon()
+	} else {
+		c.breaker.record(err)
+	}
+	return err
+}
+
+// attempt — одна попытка запроса
+func (c *Client) attempt(ctx context.Context, logger *slog.Logger, endpoint string, body []byte, idempotencyKey string, out interface{}, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderIdempotencyKey, idempotencyKey)
+	if id := RequestID(ctx); id != "" {
+		req.Header.Set(HeaderRequestID, id)
+	}
+	if len(c.secret) > 0 {
+		// Подписываем каждую попытку заново: метка времени должна попадать в окно повтора
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HeaderBotTimestamp, timestamp)
+		req.Header.Set(HeaderBotSignature, SignRequest(c.secret, timestamp, req.Method, req.URL.EscapedPath(), body))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return &NetworkError{Endpoint: endpoint, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// Читаем начало тела ответа для деталей ошибки
+		errorBody, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit))
+		return &StatusError{Endpoint: endpoint, StatusCode: resp.StatusCode, Body: string(errorBody)}
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		// Обрыв или таймаут во время чтения тела — это проблема сети, а не формата
+		return &NetworkError{Endpoint: endpoint, Err: err}
+	}
+	if logger.Enabled(ctx, slog.LevelDebug) {
+		logger.DebugContext(ctx, "Received from AI agent", "payload", c.logPayload(respBody))
+	}
+	if c.verifyResponses {
+		if err := VerifyResponse(c.secret, req, resp, respBody, DefaultReplayWindow); err != nil {
+			return &SignatureError{Endpoint: endpoint, Err: err}
+		}
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return &DecodeError{Endpoint: endpoint, Err: err}
+	}
+	return nil
+}
+
+// log — логгер клиента (slog.Default(), если не задан: он может смениться после создания клиента)
+func (c *Client) log() *slog.Logger {
+	if c.logger != nil {
+		return c.logger
+	}
+	return slog.Default()
+}
+
+// logPayload — тело для лога: без секретов и base64-данных, не длиннее payloadLogLimit байт
+func (c *Client) logPayload(body []byte) string {
+	return Truncate(Redact(body), c.payloadLogLimit)
+}
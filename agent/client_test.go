@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testClient — клиент без повторов и без лога для сервера handler
+func testClient(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	opts = append([]Option{WithRetry(RetryPolicy{MaxAttempts: 1}), WithLogger(slog.New(slog.DiscardHandler))}, opts...)
+	return NewClient(server.URL, opts...)
+}
+
+func TestClientEnvelope(t *testing.T) {
+	var got Envelope
+	var header http.Header
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		if r.URL.Path != "/generate_text" {
+			t.Errorf("path = %q, want /generate_text", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"post_id":"p1","main_text":"Готово"}`))
+	})
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	post, err := client.GenerateText(ctx, 42, GenerateTextRequest{Prompt: "Пост о субботнике"})
+	if err != nil {
+		t.Fatalf("GenerateText() error = %v", err)
+	}
+	if post.PostID != "p1" || post.MainText != "Готово" {
+		t.Errorf("GenerateText() = %+v", post)
+	}
+	if got.Endpoint != "/generate_text" || got.TgID != 42 || got.RequestID != "req-1" || got.Timestamp == 0 {
+		t.Errorf("envelope = %+v", got)
+	}
+	if got.IdempotencyKey == "" || header.Get(HeaderIdempotencyKey) != got.IdempotencyKey {
+		t.Errorf("idempotency key: envelope %q, header %q", got.IdempotencyKey, header.Get(HeaderIdempotencyKey))
+	}
+	if header.Get(HeaderRequestID) != "req-1" {
+		t.Errorf("%s = %q, want req-1", HeaderRequestID, header.Get(HeaderRequestID))
+	}
+	if header.Get(HeaderBotSignature) != "" {
+		t.Errorf("request is signed without a secret")
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr interface{} // Указатель на ожидаемый тип ошибки для errors.As
+	}{
+		{"bad request", http.StatusBadRequest, `{"error":"bad prompt"}`, new(*StatusError)},
+		{"unavailable", http.StatusServiceUnavailable, ``, new(*StatusError)},
+		{"not json", http.StatusOK, `<html>`, new(*DecodeError)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			_, err := client.EditText(context.Background(), 1, EditTextRequest{Text: "текст"})
+			if !errors.As(err, tt.wantErr) {
+				t.Errorf("EditText() error = %v (%T), want %T", err, err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("not configured", func(t *testing.T) {
+		_, err := NewClient("").EditText(context.Background(), 1, EditTextRequest{Text: "текст"})
+		if !errors.Is(err, ErrNotConfigured) {
+			t.Errorf("EditText() error = %v, want %v", err, ErrNotConfigured)
+		}
+	})
+}
+
+func TestClientSigning(t *testing.T) {
+	secret := []byte("s3cret")
+	tests := []struct {
+		name         string
+		signResponse bool
+		verify       bool
+		wantErr      error
+	}{
+		{"signed request, unsigned response", false, false, nil},
+		{"signed response verified", true, true, nil},
+		{"unsigned response rejected", false, true, ErrMissingSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if _, err := VerifyRequest(secret, r, DefaultReplayWindow); err != nil {
+					t.Errorf("VerifyRequest() error = %v", err)
+				}
+				body := []byte(`{"status":"sent"}`)
+				if tt.signResponse {
+					SignResponse(secret, w, r, body)
+				}
+				w.Write(body)
+			}, WithSigning(string(secret), tt.verify))
+
+			_, err := client.SendPost(context.Background(), 1, SendPostRequest{PostID: "p1"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SendPost() error = %v, want %v", err, tt.wantErr)
+			}
+			var sigErr *SignatureError
+			if tt.wantErr != nil && !errors.As(err, &sigErr) {
+				t.Errorf("SendPost() error = %T, want *SignatureError", err)
+			}
+		})
+	}
+}
+
+func TestClientRejectsReplayedResponse(t *testing.T) {
+	secret := []byte("s3cret")
+	// Первый ответ агент подписывает честно, на следующие вызовы отдаёт его же (перехваченный ответ)
+	var replayed http.Header
+	var replayedBody []byte
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if replayed == nil {
+			replayedBody = []byte(`{"status":"sent"}`)
+			SignResponse(secret, w, r, replayedBody)
+			replayed = w.Header().Clone()
+		} else {
+			for name, values := range replayed {
+				w.Header()[name] = values
+			}
+		}
+		w.Write(replayedBody)
+	}, WithSigning(string(secret), true))
+
+	if _, err := client.SendPost(context.Background(), 1, SendPostRequest{PostID: "p1"}); err != nil {
+		t.Fatalf("SendPost() error = %v", err)
+	}
+	_, err := client.SendPost(context.Background(), 1, SendPostRequest{PostID: "p2"})
+	if !errors.Is(err, ErrBadSignature) {
+		t.Errorf("SendPost(replayed response) error = %v, want %v", err, ErrBadSignature)
+	}
+}
+
+func TestInitUserIsNotWrapped(t *testing.T) {
+	var got map[string]interface{}
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+	resp, err := client.InitUser(context.Background(), InitUserRequest{TgID: 7, Username: "nko"})
+	if err != nil {
+		t.Fatalf("InitUser() error = %v", err)
+	}
+	if resp.Status != "ok" {
+		t.Errorf("InitUser() status = %q, want ok", resp.Status)
+	}
+	if _, wrapped := got["endpoint"]; wrapped {
+		t.Errorf("InitUser() sent an envelope: %v", got)
+	}
+}
@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotConfigured — адрес агента не задан (AI_AGENT_URL)
+var ErrNotConfigured = errors.New("AI_AGENT_URL not set")
+
+// NetworkError — агент недоступен: ошибка соединения, таймаут и т.п.
+type NetworkError struct {
+	Endpoint string
+	Err      error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("AI agent connection error (%s): %v", e.Endpoint, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error { return e.Err }
+
+// StatusError — агент ответил кодом, отличным от 200
+type StatusError struct {
+	Endpoint   string
+	StatusCode int
+	Body       string // Начало тела ответа (для деталей ошибки)
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("AI agent error (%s): status %d, details: %s", e.Endpoint, e.StatusCode, e.Body)
+}
+
+// DecodeError — ответ агента не удалось разобрать
+type DecodeError struct {
+	Endpoint string
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("AI agent response parse error (%s): %v", e.Endpoint, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Поля, значения которых не пишутся в лог
+var (
+	secretKeys = []string{"token", "secret", "password", "authorization", "api_key"}
+	binaryKeys = []string{"image_base64"}
+)
+
+// botTokenPattern — токен Telegram бота (в том числе внутри ссылок api.telegram.org/file/bot<токен>/...)
+var botTokenPattern = regexp.MustCompile(`\d{5,}:[A-Za-z0-9_-]{30,}`)
+
+// Redact — тело запроса/ответа для лога: секреты заменяются на ***, base64-данные — на их размер
+func Redact(body []byte) string {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return botTokenPattern.ReplaceAllString(string(body), "***")
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(redactValue("", value)); err != nil {
+		return "<unloggable body>"
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// Truncate — строка для лога не длиннее limit байт (по границе символа) с пометкой о полном размере
+// limit <= 0 — без ограничения.
+func Truncate(s string, limit int) string {
+	if limit <= 0 || len(s) <= limit {
+		return s
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s… (%d bytes total)", s[:cut], len(s))
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = redactValue(k, item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(key, item)
+		}
+		return v
+	case string:
+		lower := strings.ToLower(key)
+		for _, k := range binaryKeys {
+			if lower == k {
+				return fmt.Sprintf("<%d bytes>", len(v))
+			}
+		}
+		for _, k := range secretKeys {
+			if strings.Contains(lower, k) {
+				return "***"
+			}
+		}
+		return botTokenPattern.ReplaceAllString(v, "***")
+	}
+	return value
+}
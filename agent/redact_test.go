@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	const botToken = "123456789:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw1"
+	tests := []struct {
+		name string
+		body string
+		want string // JSON, сравнивается по значению; для не-JSON — строка как есть
+	}{
+		{
+			"secret keys",
+			`{"token":"abc","api_key":"k","Authorization":"Bearer x","user_password":"p","name":"НКО"}`,
+			`{"token":"***","api_key":"***","Authorization":"***","user_password":"***","name":"НКО"}`,
+		},
+		{
+			"nested maps and arrays",
+			`{"data":{"nko":{"secret":"s","name":"n"},"content":[{"data":{"image_base64":"aGVsbG8=","x":1}}]}}`,
+			`{"data":{"nko":{"secret":"***","name":"n"},"content":[{"data":{"image_base64":"<8 bytes>","x":1}}]}}`,
+		},
+		{
+			"bot token inside a value",
+			`{"url":"https://api.telegram.org/file/bot` + botToken + `/photos/1.jpg"}`,
+			`{"url":"https://api.telegram.org/file/bot***/photos/1.jpg"}`,
+		},
+		{
+			"array of secrets",
+			`{"tokens":["a","b"]}`,
+			`{"tokens":["***","***"]}`,
+		},
+		{
+			"numbers and nulls are kept",
+			`{"tg_id":123,"token":null,"ok":true}`,
+			`{"tg_id":123,"token":null,"ok":true}`,
+		},
+		{
+			"not json",
+			`bad gateway for bot` + botToken,
+			`bad gateway for bot***`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Redact([]byte(tt.body))
+			var gotValue, wantValue interface{}
+			if json.Unmarshal([]byte(tt.want), &wantValue) != nil {
+				if got != tt.want {
+					t.Errorf("Redact() = %q, want %q", got, tt.want)
+				}
+				return
+			}
+			if err := json.Unmarshal([]byte(got), &gotValue); err != nil {
+				t.Fatalf("Redact() = %q is not JSON: %v", got, err)
+			}
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("Redact() = %s, want %s", got, tt.want)
+			}
+			if strings.Contains(got, botToken) {
+				t.Errorf("Redact() leaks the bot token: %s", got)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		limit int
+		want  string
+	}{
+		{"short", "abc", 10, "abc"},
+		{"no limit", "abcdef", 0, "abcdef"},
+		{"cut", "abcdef", 3, "abc… (6 bytes total)"},
+		{"rune boundary", "приветик", 3, "п… (16 bytes total)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Truncate(tt.s, tt.limit); got != tt.want {
+				t.Errorf("Truncate(%q, %d) = %q, want %q", tt.s, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// HeaderRequestID — заголовок с ID запроса (совпадает с request_id в конверте)
+const HeaderRequestID = "X-Request-ID"
+
+// HeaderIdempotencyKey — заголовок с ключом идемпотентности (совпадает с idempotency_key в конверте)
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+type requestIDKey struct{}
+
+// WithRequestID — контекст с ID запроса (correlation ID)
+// Клиент передаёт его агенту в конверте (request_id) и заголовке X-Request-ID и пишет в лог,
+// так что запрос можно проследить от обновления Telegram до логов агента.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID — ID запроса из контекста (пустой, если не задан)
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID — случайный ID запроса
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
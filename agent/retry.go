@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	mathrand "math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy — повторы запросов при временных сбоях
+type RetryPolicy struct {
+	MaxAttempts int           // Всего попыток, включая первую (1 — без повторов)
+	BaseDelay   time.Duration // Пауза перед первым повтором; дальше удваивается
+	MaxDelay    time.Duration // Верхняя граница паузы
+}
+
+// DefaultRetryPolicy — 3 попытки с паузами до 0.5 с и 1 с (со случайным разбросом)
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 5 * time.Second}
+
+// WithRetry — своя политика повторов
+func WithRetry(policy RetryPolicy) Option {
+	return func(c *Client) {
+		if policy.MaxAttempts < 1 {
+			policy.MaxAttempts = 1
+		}
+		c.retry = policy
+	}
+}
+
+// delay — пауза перед повтором номер attempt (1, 2, ...): экспоненциальный рост и «полный» jitter,
+// чтобы повторы от разных пользователей не приходили в агент одновременно
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(mathrand.Int63n(int64(d)) + 1)
+}
+
+// isTransient — временный ли сбой: сеть, таймаут, перегрузка или недоступность агента
+// Такие ошибки повторяются и учитываются автоматом отключения.
+func isTransient(err error) bool {
+	var netErr *NetworkError
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+	return false
+}
+
+// sleep — пауза, прерываемая отменой контекста
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// newIdempotencyKey — ключ идемпотентности: один на логический вызов, общий для всех его повторов
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt int
+		max     time.Duration // Верхняя граница паузы (с jitter пауза в (0, max])
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{40, time.Second}, // Сдвиг переполняет time.Duration — всё равно MaxDelay
+		{70, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint("attempt ", tt.attempt), func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if d := policy.delay(tt.attempt); d <= 0 || d > tt.max {
+					t.Fatalf("delay(%d) = %v, want (0, %v]", tt.attempt, d, tt.max)
+				}
+			}
+		})
+	}
+
+	if d := (RetryPolicy{MaxAttempts: 3}).delay(1); d != 0 {
+		t.Errorf("delay without BaseDelay/MaxDelay = %v, want 0", d)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network", &NetworkError{Endpoint: "/x", Err: errors.New("connection refused")}, true},
+		{"wrapped network", fmt.Errorf("call: %w", &NetworkError{Endpoint: "/x", Err: errors.New("eof")}), true},
+		{"429", &StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"502", &StatusError{StatusCode: http.StatusBadGateway}, true},
+		{"503", &StatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"504", &StatusError{StatusCode: http.StatusGatewayTimeout}, true},
+		{"400", &StatusError{StatusCode: http.StatusBadRequest}, false},
+		{"500", &StatusError{StatusCode: http.StatusInternalServerError}, false},
+		{"decode", &DecodeError{Endpoint: "/x", Err: errors.New("bad json")}, false},
+		{"signature", &SignatureError{Endpoint: "/x", Err: ErrBadSignature}, false},
+		{"canceled", context.Canceled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.err); got != tt.want {
+				t.Errorf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int // Ответы агента по порядку попыток (дальше — 200)
+		maxAttempts  int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"success", nil, 3, 1, false},
+		{"transient then success", []int{503, 502}, 3, 3, false},
+		{"attempts exhausted", []int{503, 503, 503, 503}, 3, 3, true},
+		{"permanent error is not retried", []int{400}, 3, 1, true},
+		{"no retries", []int{503}, 1, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var keys []string
+			client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+				var envelope Envelope
+				json.NewDecoder(r.Body).Decode(&envelope)
+				mu.Lock()
+				keys = append(keys, envelope.IdempotencyKey)
+				attempt := len(keys)
+				mu.Unlock()
+				if attempt <= len(tt.statuses) {
+					w.WriteHeader(tt.statuses[attempt-1])
+					return
+				}
+				w.Write([]byte(`{"main_text":"ok"}`))
+			}, WithRetry(RetryPolicy{MaxAttempts: tt.maxAttempts, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+				WithCircuitBreaker(0, 0))
+
+			_, err := client.EditText(context.Background(), 1, EditTextRequest{Text: "текст"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EditText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(keys) != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", len(keys), tt.wantAttempts)
+			}
+			for _, key := range keys {
+				if key == "" || key != keys[0] {
+					t.Errorf("idempotency keys differ between attempts: %q", keys)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Заголовки подписи (схема описана в AI_AGENT_FORMAT.md, раздел «Подпись запросов»)
+const (
+	HeaderBotTimestamp   = "X-Bot-Timestamp"   // Время подписи запроса бота (unix, секунды)
+	HeaderBotSignature   = "X-Bot-Signature"   // sha256=<hex HMAC-SHA256(secret, timestamp + "." + METHOD + "\n" + path + "\n" + body)>
+	HeaderAgentTimestamp = "X-Agent-Timestamp" // То же для ответа агента
+	HeaderAgentSignature = "X-Agent-Signature" // sha256=<hex HMAC-SHA256(secret, timestamp + "." + METHOD + "\n" + path + "\n" + Idempotency-Key + "\n" + body)>
+
+	signaturePrefix = "sha256="
+)
+
+// DefaultReplayWindow — насколько подпись может отличаться по времени от текущего момента
+const DefaultReplayWindow = 5 * time.Minute
+
+var (
+	ErrMissingSignature = errors.New("signature headers are missing")
+	ErrBadSignature     = errors.New("signature mismatch")
+	ErrStaleSignature   = errors.New("signature timestamp is outside the replay window")
+)
+
+// SignatureError — ответ агента не прошёл проверку подписи
+type SignatureError struct {
+	Endpoint string
+	Err      error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("AI agent response signature error (%s): %v", e.Endpoint, e.Err)
+}
+
+func (e *SignatureError) Unwrap() error { return e.Err }
+
+// WithSigning — подписывать запросы общим секретом (AI_AGENT_SECRET)
+// verifyResponses — требовать подписанные ответы (X-Agent-Timestamp / X-Agent-Signature).
+func WithSigning(secret string, verifyResponses bool) Option {
+	return func(c *Client) {
+		c.secret = []byte(secret)
+		c.verifyResponses = verifyResponses && secret != ""
+	}
+}
+
+// Sign — подпись тела с меткой времени: sha256=<hex HMAC-SHA256(secret, timestamp + "." + body)>
+// Запросы бота подписываются через SignRequest, ответы агента — через SignResponse.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest — подпись запроса бота: кроме тела и времени в неё входят метод и путь,
+// чтобы перехваченный запрос нельзя было отправить на другой endpoint агента.
+func SignRequest(secret []byte, timestamp, method, path string, body []byte) string {
+	return Sign(secret, timestamp, requestPayload(method, path, body))
+}
+
+// requestPayload — что подписывается в запросе после метки времени: METHOD + "\n" + path + "\n" + body
+func requestPayload(method, path string, body []byte) []byte {
+	return signedPayload(body, method, path)
+}
+
+// responsePayload — что подписывается в ответе после метки времени:
+// METHOD + "\n" + path + "\n" + Idempotency-Key + "\n" + body, где метод, путь и ключ — из запроса бота
+func responsePayload(method, path, idempotencyKey string, body []byte) []byte {
+	return signedPayload(body, method, path, idempotencyKey)
+}
+
+// signedPayload — поля, каждое с переводом строки, и за ними тело
+func signedPayload(body []byte, fields ...string) []byte {
+	size := len(body)
+	for _, field := range fields {
+		size += len(field) + 1
+	}
+	payload := make([]byte, 0, size)
+	for _, field := range fields {
+		payload = append(payload, field...)
+		payload = append(payload, '\n')
+	}
+	return append(payload, body...)
+}
+
+// Verify — проверка подписи и окна повтора (подпись старше/новее window отклоняется)
+func Verify(secret []byte, timestamp, signature string, body []byte, window time.Duration, now time.Time) error {
+	if timestamp == "" || signature == "" {
+		return ErrMissingSignature
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	skew := now.Sub(time.Unix(ts, 0))
+	if skew > window || skew < -window {
+		return ErrStaleSignature
+	}
+	if !strings.HasPrefix(signature, signaturePrefix) ||
+		!hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature)) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// VerifyRequest — проверка запроса бота на стороне агента (если агент написан на Go)
+// Метод и путь берутся из самого запроса. Возвращает прочитанное тело, чтобы его можно было разобрать.
+func VerifyRequest(secret []byte, r *http.Request, window time.Duration) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	payload := requestPayload(r.Method, r.URL.EscapedPath(), body)
+	err = Verify(secret, r.Header.Get(HeaderBotTimestamp), r.Header.Get(HeaderBotSignature), payload, window, time.Now())
+	return body, err
+}
+
+// SignResponse — подписать ответ агента на запрос r (заголовки ставятся до WriteHeader)
+// Подпись привязана к методу, пути и Idempotency-Key запроса: ответ на один вызов
+// нельзя выдать за ответ на другой.
+func SignResponse(secret []byte, w http.ResponseWriter, r *http.Request, body []byte) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	payload := responsePayload(r.Method, r.URL.EscapedPath(), r.Header.Get(HeaderIdempotencyKey), body)
+	w.Header().Set(HeaderAgentTimestamp, timestamp)
+	w.Header().Set(HeaderAgentSignature, Sign(secret, timestamp, payload))
+}
+
+// VerifyResponse — проверка ответа агента resp с телом body на запрос бота req
+func VerifyResponse(secret []byte, req *http.Request, resp *http.Response, body []byte, window time.Duration) error {
+	payload := responsePayload(req.Method, req.URL.EscapedPath(), req.Header.Get(HeaderIdempotencyKey), body)
+	return Verify(secret, resp.Header.Get(HeaderAgentTimestamp), resp.Header.Get(HeaderAgentSignature), payload, window, time.Now())
+}
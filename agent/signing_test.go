@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyRequest(t *testing.T) {
+	secret := []byte("s3cret")
+	body := `{"endpoint":"/generate_text","data":{"prompt":"hi"}}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	signed := SignRequest(secret, now, "POST", "/generate_text", []byte(body))
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		body      string
+		timestamp string
+		signature string
+		secret    []byte
+		want      error
+	}{
+		{"valid", "POST", "/generate_text", body, now, signed, secret, nil},
+		{"tampered body", "POST", "/generate_text", strings.Replace(body, "hi", "bye", 1), now, signed, secret, ErrBadSignature},
+		{"other endpoint", "POST", "/generate_image", body, now, signed, secret, ErrBadSignature},
+		{"other method", "PUT", "/generate_text", body, now, signed, secret, ErrBadSignature},
+		{"other secret", "POST", "/generate_text", body, now, signed, []byte("other"), ErrBadSignature},
+		{"tampered timestamp", "POST", "/generate_text", body, strconv.FormatInt(time.Now().Unix()-1, 10), signed, secret, ErrBadSignature},
+		{"no prefix", "POST", "/generate_text", body, now, strings.TrimPrefix(signed, "sha256="), secret, ErrBadSignature},
+		{"bad timestamp", "POST", "/generate_text", body, "yesterday", signed, secret, ErrBadSignature},
+		{"no timestamp", "POST", "/generate_text", body, "", signed, secret, ErrMissingSignature},
+		{"no signature", "POST", "/generate_text", body, now, "", secret, ErrMissingSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://agent.local"+tt.path, strings.NewReader(tt.body))
+			if tt.timestamp != "" {
+				r.Header.Set(HeaderBotTimestamp, tt.timestamp)
+			}
+			if tt.signature != "" {
+				r.Header.Set(HeaderBotSignature, tt.signature)
+			}
+			got, err := VerifyRequest(tt.secret, r, DefaultReplayWindow)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyRequest() error = %v, want %v", err, tt.want)
+			}
+			if string(got) != tt.body {
+				t.Errorf("VerifyRequest() body = %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestVerifyReplayWindow(t *testing.T) {
+	secret := []byte("s3cret")
+	body := []byte(`{"status":"ok"}`)
+	now := time.Unix(1703520000, 0)
+
+	tests := []struct {
+		name string
+		skew time.Duration // Насколько метка времени старше текущего момента
+		want error
+	}{
+		{"now", 0, nil},
+		{"inside window", DefaultReplayWindow - time.Second, nil},
+		{"edge of window", DefaultReplayWindow, nil},
+		{"stale", DefaultReplayWindow + time.Second, ErrStaleSignature},
+		{"slightly in future", -time.Minute, nil},
+		{"far in future", -DefaultReplayWindow - time.Second, ErrStaleSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timestamp := strconv.FormatInt(now.Add(-tt.skew).Unix(), 10)
+			err := Verify(secret, timestamp, Sign(secret, timestamp, body), body, DefaultReplayWindow, now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyResponse(t *testing.T) {
+	secret := []byte("s3cret")
+	body := `{"post_id":"p1"}`
+
+	// Ответ агента подписан для запроса A
+	requestA := httptest.NewRequest("POST", "http://agent.local/generate_text", nil)
+	requestA.Header.Set(HeaderIdempotencyKey, "key-a")
+	w := httptest.NewRecorder()
+	SignResponse(secret, w, requestA, []byte(body))
+	response := &http.Response{Header: w.Header()}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		body   string
+		want   error
+	}{
+		{"same request", "POST", "/generate_text", "key-a", body, nil},
+		{"other call", "POST", "/generate_text", "key-b", body, ErrBadSignature},
+		{"other endpoint", "POST", "/generate_image", "key-a", body, ErrBadSignature},
+		{"other method", "PUT", "/generate_text", "key-a", body, ErrBadSignature},
+		{"tampered body", "POST", "/generate_text", "key-a", `{"post_id":"p2"}`, ErrBadSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, "http://agent.local"+tt.path, nil)
+			request.Header.Set(HeaderIdempotencyKey, tt.key)
+			err := VerifyResponse(secret, request, response, []byte(tt.body), DefaultReplayWindow)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("VerifyResponse() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package agent
+
+// Envelope — обёртка запроса от бота к AI агенту (все endpoints, кроме /api/auth/init)
+type Envelope struct {
+	Endpoint  string      `json:"endpoint"`  // Куда AI агент должен обратиться в бэкенде
+	Data      interface{} `json:"data"`      // Данные для обработки
+	TgID      int64       `json:"tg_id"`     // ID пользователя Telegram (обязательно для бэкенда)
+	Timestamp int64       `json:"timestamp"` // Время отправки (unix)
+
+	// Ключ идемпотентности: одинаковый у всех повторов одного вызова (дублируется в заголовке Idempotency-Key)
+	IdempotencyKey string `json:"idempotency_key"`
+
+	// ID запроса (correlation ID) обновления Telegram, из которого вызван агент (дублируется в заголовке X-Request-ID)
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// NKO — данные об организации, которые агент использует для улучшения промпта
+type NKO struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Activities  string `json:"activities"`
+	Style       string `json:"style"`
+}
+
+// InitUserRequest — /api/auth/init (отправляется напрямую, без обёртки)
+type InitUserRequest struct {
+	TgID     int64  `json:"tg_id"`
+	Username string `json:"username"`
+}
+
+// InitUserResponse — ответ на /api/auth/init
+type InitUserResponse struct {
+	Status string `json:"status"`
+	TgID   int64  `json:"tg_id"`
+}
+
+// GenerateTextRequest — /generate_text (свободная и структурированная форма)
+type GenerateTextRequest struct {
+	Prompt string `json:"prompt"`
+	NKO    NKO    `json:"nko"`
+}
+
+// GenerateImageRequest — /generate_image (по описанию или по загруженному изображению)
+// Загруженное изображение передаётся байтами: ссылки на файлы Telegram содержат токен бота.
+type GenerateImageRequest struct {
+	Desc        string  `json:"desc"`
+	ImageBase64 string  `json:"image_base64,omitempty"` // Исходное изображение (base64)
+	ImageMIME   string  `json:"image_mime,omitempty"`   // Его тип, например image/jpeg
+	FileID      string  `json:"file_id,omitempty"`      // file_id в Telegram (для справки, скачать по нему агент не может)
+	Canvas      *Canvas `json:"canvas,omitempty"`       // Формат, выбранный пользователем
+	NKO         NKO     `json:"nko"`
+}
+
+// EditTextRequest — /edit_text
+type EditTextRequest struct {
+	Text string `json:"text"`
+}
+
+// EditTextResponse — исправленный текст
+type EditTextResponse struct {
+	PostID   string `json:"post_id"`
+	MainText string `json:"main_text"`
+}
+
+// ContentPlanRequest — /content_plan
+type ContentPlanRequest struct {
+	Days string `json:"days"` // Количество дней (строкой, как в формате агента)
+	Freq string `json:"freq"` // «ежедневно», «через день», «2 раза в неделю», «3 раза в неделю»
+	NKO  NKO    `json:"nko"`
+}
+
+// ContentPlanResponse — текст контент-плана
+type ContentPlanResponse struct {
+	MainText string `json:"main_text"`
+}
+
+// SendPostRequest — /send_post
+type SendPostRequest struct {
+	PostID string `json:"post_id"`
+	ChatID string `json:"chat_id"` // chat_id или @username канала/группы
+}
+
+// SendPostResponse — результат публикации
+type SendPostResponse struct {
+	Status string `json:"status"`
+}
+
+// RegeneratePostRequest — /regenerate_post
+type RegeneratePostRequest struct {
+	PostID     string `json:"post_id"`
+	Regenerate bool   `json:"regenerate"`
+}
+
+// Post — формат поста от агента (PostJSON)
+type Post struct {
+	PostID         string  `json:"post_id"`
+	PostAuthor     int64   `json:"post_author"`
+	AssignedChatID []int64 `json:"assigned_chat_id"`
+	MainText       string  `json:"main_text"`
+	Canvas         *Canvas `json:"canvas,omitempty"` // Холст изображения (nil — по настройкам бота)
+	Content        []Layer `json:"content"`
+}
+
+// Canvas — холст изображения поста
+// Preset — готовый формат (названия — в AI_AGENT_FORMAT.md), Width и Height его переопределяют.
+type Canvas struct {
+	Preset          string                 `json:"preset,omitempty"`
+	Width           int                    `json:"width,omitempty"`
+	Height          int                    `json:"height,omitempty"`
+	Background      string                 `json:"background,omitempty"`       // Цвет фона #RRGGBB или #RRGGBBAA
+	BackgroundImage map[string]interface{} `json:"background_image,omitempty"` // Фоновая картинка: поля слоя image
+}
+
+// Layer — слой изображения поста
+type Layer struct {
+	LayerID    string                 `json:"layer_id"`
+	Type       string                 `json:"type"`
+	OrderIndex int                    `json:"order_index"`
+	Data       map[string]interface{} `json:"data"`
+}
@@ -0,0 +1,117 @@
+// atomicfile.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeFileAtomic — записывает файл через временный файл + rename
+// Данные сначала пишутся во временный файл в той же директории и сбрасываются на диск (fsync),
+// затем файл атомарно переименовывается поверх старого. При падении процесса на диске
+// остаётся либо старая, либо новая версия файла, но не обрезанная.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+	// Если что-то пошло не так — убираем временный файл
+	defer func() {
+		if tmpName != "" {
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return fmt.Errorf("chmod temp file: %w", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+	tmpName = ""
+
+	// Сбрасываем на диск саму директорию, чтобы rename пережил падение питания
+	syncDir(dir)
+	return nil
+}
+
+// removeStaleTempFiles — удаляет временные файлы writeFileAtomic (для path и его копий .bak.N),
+// оставшиеся после падения процесса посреди записи. Вызывается при запуске, пока файл никто не пишет.
+func removeStaleTempFiles(path string) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	prefix := "." + filepath.Base(path) + "."
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.Contains(name[len(prefix)-1:], ".tmp-") {
+			continue
+		}
+		stale := filepath.Join(filepath.Dir(path), name)
+		if err := os.Remove(stale); err == nil {
+			slog.Warn("Removed temp file left by an interrupted write", "file", stale)
+		}
+	}
+}
+
+// syncDir — fsync директории (на Windows не поддерживается, ошибки игнорируем)
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// backupPath — путь к n-ной резервной копии файла (1 — самая свежая)
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.bak.%d", path, n)
+}
+
+// rotateBackups — сдвигает резервные копии (.bak.1 → .bak.2 …) и копирует текущий файл в .bak.1
+// Самая старая копия сверх лимита удаляется. Если исходного файла нет — ничего не делаем,
+// если он не является валидным JSON — возвращаем ошибку, не трогая хорошие копии.
+func rotateBackups(path string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	current, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read %s for backup: %w", path, err)
+	}
+	if !json.Valid(current) {
+		return fmt.Errorf("%s is not valid JSON, backup skipped", path)
+	}
+
+	os.Remove(backupPath(path, keep))
+	for n := keep - 1; n >= 1; n-- {
+		if _, err := os.Stat(backupPath(path, n)); err == nil {
+			if err := os.Rename(backupPath(path, n), backupPath(path, n+1)); err != nil {
+				return fmt.Errorf("rotate backup %d: %w", n, err)
+			}
+		}
+	}
+	return writeFileAtomic(backupPath(path, 1), current, 0644)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tempFiles — временные файлы writeFileAtomic в каталоге dir
+func tempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(path string) // Что лежит на месте файла до записи
+		want    string            // Содержимое после записи (пусто — запись должна не удаться)
+	}{
+		{"new file", func(string) {}, "new"},
+		{"overwrite", func(path string) { os.WriteFile(path, []byte("old"), 0644) }, "new"},
+		{"rename fails", func(path string) { os.Mkdir(path, 0755) }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "data.json")
+			tt.prepare(path)
+
+			err := writeFileAtomic(path, []byte("new"), 0644)
+			if (err != nil) != (tt.want == "") {
+				t.Fatalf("writeFileAtomic() error = %v", err)
+			}
+			if tt.want != "" {
+				if got, _ := os.ReadFile(path); string(got) != tt.want {
+					t.Errorf("file = %q, want %q", got, tt.want)
+				}
+			}
+			// Прерванная запись не оставляет временных файлов
+			if left := tempFiles(t, dir); len(left) > 0 {
+				t.Errorf("temp files left: %v", left)
+			}
+		})
+	}
+}
+
+func TestRemoveStaleTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+	files := map[string]bool{ // имя → должно остаться
+		"data.json":                  true,
+		"data.json.bak.1":            true,
+		".data.json.tmp-123":         false, // Запись основного файла прервана
+		".data.json.bak.1.tmp-456":   false, // Запись копии прервана
+		".other.json.tmp-789":        true,
+		"data.json.corrupt-20240101": true,
+	}
+	for name := range files {
+		os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644)
+	}
+
+	removeStaleTempFiles(path)
+	for name, keep := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != keep {
+			t.Errorf("%s exists = %v, want %v", name, exists, keep)
+		}
+	}
+}
+
+func TestRotateBackups(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string // Текущий файл ("" — его нет)
+		backups int    // Сколько копий уже есть (.bak.N содержит {"n":N})
+		keep    int
+		want    []string // Содержимое .bak.1, .bak.2, … после ротации
+		wantErr bool
+	}{
+		{"first backup", `{"n":0}`, 0, 3, []string{`{"n":0}`}, false},
+		{"shift", `{"n":0}`, 2, 3, []string{`{"n":0}`, `{"n":1}`, `{"n":2}`}, false},
+		{"oldest dropped at keep", `{"n":0}`, 3, 3, []string{`{"n":0}`, `{"n":1}`, `{"n":2}`}, false},
+		{"keep one", `{"n":0}`, 1, 1, []string{`{"n":0}`}, false},
+		{"no backups", `{"n":0}`, 0, 0, nil, false},
+		{"no file", "", 2, 3, []string{`{"n":1}`, `{"n":2}`}, false},
+		{"corrupt file keeps backups", `{"n":`, 2, 3, []string{`{"n":1}`, `{"n":2}`}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "data.json")
+			if tt.current != "" {
+				os.WriteFile(path, []byte(tt.current), 0644)
+			}
+			for n := 1; n <= tt.backups; n++ {
+				os.WriteFile(backupPath(path, n), []byte(fmt.Sprintf(`{"n":%d}`, n)), 0644)
+			}
+
+			if err := rotateBackups(path, tt.keep); (err != nil) != tt.wantErr {
+				t.Fatalf("rotateBackups() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for n := 1; n <= len(tt.want); n++ {
+				if got, _ := os.ReadFile(backupPath(path, n)); string(got) != tt.want[n-1] {
+					t.Errorf(".bak.%d = %q, want %q", n, got, tt.want[n-1])
+				}
+			}
+			if _, err := os.Stat(backupPath(path, len(tt.want)+1)); err == nil {
+				t.Errorf(".bak.%d exists, want at most %d backups", len(tt.want)+1, len(tt.want))
+			}
+		})
+	}
+}
@@ -2,140 +2,56 @@ package main
 
 import (
 	"bytes"
-	"encoding/base64"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/jpeg"
-	"log"
-	"net/http"
-	"os"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
 
+	"nko-bot-frontend/agent"
+
 	"github.com/fogleman/gg"
-	"golang.org/x/image/font/basicfont"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
-// CallAIAgent — отправка данных в AI агента (который работает на другом устройстве)
+// aiAgent — клиент AI агента (который работает на другом устройстве), создаётся в main.go
 // Архитектура: Бот → AI агент (удалённый) → Бэкенд
 // AI агент получает JSON от бота, формирует промпт и вызывает бэкенд
-func CallAIAgent(endpoint string, data map[string]interface{}, tgID int64) (PostJSON, error) {
-	aiAgentURL := os.Getenv("AI_AGENT_URL")
-	if aiAgentURL == "" {
-		return PostJSON{}, fmt.Errorf("AI_AGENT_URL not set in .env")
-	}
-
-	// Добавляем метаданные для AI агента
-	requestData := map[string]interface{}{
-		"endpoint":  endpoint, // Куда AI агент должен обратиться в бэкенде
-		"data":      data,     // Данные для обработки
-		"tg_id":     tgID,     // ID пользователя Telegram (обязательно для бэкенда)
-		"timestamp": time.Now().Unix(),
-	}
-
-	body, err := json.Marshal(requestData)
-	if err != nil {
-		return PostJSON{}, err
-	}
-
-	// Логируем отправляемый JSON для отладки
-	log.Printf("[DEBUG] Sending to AI agent (%s): %s", endpoint, string(body))
-
-	// Создаём HTTP клиент с таймаутом
-	client := &http.Client{
-		Timeout: 60 * time.Second, // Таймаут 60 секунд для генерации
-	}
-
-	resp, err := client.Post(aiAgentURL+endpoint, "application/json", bytes.NewBuffer(body))
-	if err != nil {
-		return PostJSON{}, fmt.Errorf("AI agent connection error: %w", err)
+var aiAgent = agent.NewClient("")
+
+// agentErrorText — текст ошибки AI агента для пользователя
+// Недоступность агента объясняем по-человечески, остальные ошибки показываем как есть.
+func agentErrorText(err error) string {
+	var netErr *agent.NetworkError
+	switch {
+	case errors.Is(err, agent.ErrCircuitOpen):
+		return "сервис генерации временно недоступен. Попробуй через минуту"
+	case errors.As(err, &netErr):
+		return "сервис генерации не отвечает. Попробуй чуть позже"
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		// Читаем тело ответа для деталей ошибки
-		bodyBytes := make([]byte, 1024)
-		n, _ := resp.Body.Read(bodyBytes)
-		errorBody := string(bodyBytes[:n])
-
-		return PostJSON{}, fmt.Errorf("AI agent error: status %d, details: %s", resp.StatusCode, errorBody)
-	}
-
-	var post PostJSON // из models.go
-	if err := json.NewDecoder(resp.Body).Decode(&post); err != nil {
-		return PostJSON{}, fmt.Errorf("AI agent response parse error: %w", err)
-	}
-
-	return post, nil
-}
-
-// CallBackend — отправка данных в AI агента (обёртка для удобства)
-func CallBackend(endpoint string, data map[string]interface{}, tgID int64) (PostJSON, error) {
-	return CallAIAgent(endpoint, data, tgID)
+	return err.Error()
 }
 
-// InitUser — инициализация пользователя в бэкенде через AI агента
-// Отправляет данные напрямую в формате InitUserRequest (без обёртки)
-func InitUser(tgID int64, username string) error {
-	aiAgentURL := os.Getenv("AI_AGENT_URL")
-	if aiAgentURL == "" {
-		return fmt.Errorf("AI_AGENT_URL not set in .env")
-	}
-
-	// Формируем запрос в формате, который ожидает AI агент (прямой формат)
-	requestData := map[string]interface{}{
-		"tg_id":    tgID,
-		"username": username,
-	}
-
-	body, err := json.Marshal(requestData)
-	if err != nil {
-		return err
-	}
-
-	log.Printf("[DEBUG] Initializing user: tg_id=%d, username=%s", tgID, username)
-	log.Printf("[DEBUG] Sending to AI agent (/api/auth/init): %s", string(body))
-
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	resp, err := client.Post(aiAgentURL+"/api/auth/init", "application/json", bytes.NewBuffer(body))
-	if err != nil {
-		return fmt.Errorf("AI agent connection error: %w", err)
+// agentNKO — данные НКО в формате запроса к AI агенту
+func agentNKO(nko NKOData) agent.NKO {
+	return agent.NKO{
+		Name:        nko.Name,
+		Description: nko.Description,
+		Activities:  nko.Activities,
+		Style:       nko.Style,
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes := make([]byte, 1024)
-		n, _ := resp.Body.Read(bodyBytes)
-		errorBody := string(bodyBytes[:n])
-		return fmt.Errorf("AI agent error: status %d, details: %s", resp.StatusCode, errorBody)
-	}
-
-	// Читаем ответ от AI агента
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Printf("[WARN] Failed to decode response: %v", err)
-	}
-
-	log.Printf("[DEBUG] User initialized successfully: tg_id=%d, response: %v", tgID, result)
-	return nil
 }
 
-// SendPostToUser — отправка сгенерированного поста в чат (текст + объединённое изображение из слоёв)
-func SendPostToUser(chatID int64, post PostJSON, bot *tgbotapi.BotAPI) error {
-	// Отправляем основной текст
-	if post.MainText != "" {
-		msg := tgbotapi.NewMessage(chatID, post.MainText)
-		bot.Send(msg)
-	}
-
+// SendPostImage — отправка изображения поста в чат (слои объединяются в одну картинку)
+// Текст поста показывается отдельно — им заменяется сообщение «⏳ …» задачи генерации.
+// ctx ограничивает скачивание картинок слоёв (image_url, file_id).
+func SendPostImage(ctx context.Context, chatID int64, post PostJSON, bot *tgbotapi.BotAPI) error {
 	// Если есть слои, объединяем их в одно изображение
 	if len(post.Content) > 0 {
 		// Сортируем слои по order_index (используем поле из структуры Layer)
@@ -150,12 +66,13 @@ func SendPostToUser(chatID int64, post PostJSON, bot *tgbotapi.BotAPI) error {
 		}
 
 		// Объединяем слои в одно изображение
-		finalImageBytes, err := composeLayers(layers)
+		finalImageBytes, report, err := composeLayers(ctx, bot, post.Canvas, layers)
 		if err != nil {
-			log.Printf("[ERROR] Failed to compose layers: %v", err)
+			slog.Error("Failed to compose layers", "post_id", post.PostID, "err", err)
 			// Fallback: отправляем изображения отдельно, если не удалось объединить
-			return sendLayersSeparately(chatID, post.Content, bot)
+			return sendLayersSeparately(ctx, chatID, post.Content, bot)
 		}
+		report.log(post.PostID)
 
 		// Отправляем итоговое изображение
 		photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{
@@ -163,167 +80,191 @@ func SendPostToUser(chatID int64, post PostJSON, bot *tgbotapi.BotAPI) error {
 			Bytes: finalImageBytes,
 		})
 		bot.Send(photo)
+
+		// Пропущенные слои видны не только в логе: пользователь должен знать, что картинка неполная
+		if notice := report.notice(); notice != "" {
+			bot.Send(tgbotapi.NewMessage(chatID, notice))
+		}
 	}
 
 	return nil
 }
 
-// composeLayers — объединяет все слои в одно изображение
-func composeLayers(layers []Layer) ([]byte, error) {
-	// Определяем размеры canvas (по умолчанию 1080x1080 для квадратного поста)
-	canvasWidth := 1080
-	canvasHeight := 1080
+// maxCanvasSide — предел стороны холста (защита от слоёв с огромными координатами)
+const maxCanvasSide = 4096
 
-	// Ищем максимальные размеры из всех слоёв
-	for _, layer := range layers {
-		if x, ok := layer.Data["x"].(float64); ok {
-			if w, ok := layer.Data["w"].(float64); ok {
-				if int(x+w) > canvasWidth {
-					canvasWidth = int(x + w)
-				}
-			}
-		}
-		if y, ok := layer.Data["y"].(float64); ok {
-			if h, ok := layer.Data["h"].(float64); ok {
-				if int(y+h) > canvasHeight {
-					canvasHeight = int(y + h)
-				}
-			}
-		}
-	}
+// errUnknownLayerType — слой неизвестного типа (пропускается)
+var errUnknownLayerType = errors.New("unknown layer type")
 
-	// Создаём canvas
-	dc := gg.NewContext(canvasWidth, canvasHeight)
-	dc.SetRGB(1, 1, 1) // Белый фон
-	dc.Clear()
+// renderWarning — слой, который нарисован не так, как просил агент, или пропущен
+type renderWarning struct {
+	LayerID string
+	Type    string
+	Reason  string // unknown_type, unknown_blend_mode, draw_failed, …_canvas_… (метка метрики)
+	Err     error
+}
 
-	// Рисуем слои по порядку
-	for _, layer := range layers {
-		switch layer.Type {
-		case "rectangle":
-			drawRectangle(dc, layer.Data)
-		case "image":
-			if err := drawImage(dc, layer.Data); err != nil {
-				log.Printf("[WARN] Failed to draw image layer: %v", err)
-			}
-		case "text":
-			drawText(dc, layer.Data)
-		}
-	}
+// maxNoticeWarnings — сколько предупреждений перечислять в сообщении пользователю (остальные — числом)
+const maxNoticeWarnings = 5
+
+// renderWarningReasons — причины предупреждений для сообщения пользователю
+var renderWarningReasons = map[string]string{
+	"unknown_type":          "неизвестный тип слоя, пропущен",
+	"unknown_blend_mode":    "неизвестный режим наложения, использован normal",
+	"draw_failed":           "не удалось нарисовать, пропущен",
+	"unknown_canvas_preset": "неизвестный формат холста, использован размер по умолчанию",
+	"bad_canvas_size":       "неверный размер холста, использован размер по умолчанию",
+	"bad_canvas_background": "неверный цвет фона холста, использован цвет по умолчанию",
+}
 
-	// Конвертируем в JPEG байты
-	var buf bytes.Buffer
-	if err := jpeg.Encode(&buf, dc.Image(), &jpeg.Options{Quality: 90}); err != nil {
-		return nil, fmt.Errorf("failed to encode image: %w", err)
-	}
+// renderReport — отчёт о сборке картинки поста
+type renderReport struct {
+	Layers   int // Сколько слоёв было в посте
+	Drawn    int // Сколько нарисовано
+	Warnings []renderWarning
+}
 
-	return buf.Bytes(), nil
+// warn — добавить предупреждение о слое
+func (r *renderReport) warn(layer Layer, reason string, err error) {
+	r.Warnings = append(r.Warnings, renderWarning{LayerID: layer.LayerID, Type: layer.Type, Reason: reason, Err: err})
+	renderWarningsTotal.WithLabelValues(reason).Inc()
 }
 
-// drawRectangle — рисует прямоугольник
-func drawRectangle(dc *gg.Context, data map[string]interface{}) {
-	x := getFloat(data, "x", 0)
-	y := getFloat(data, "y", 0)
-	w := getFloat(data, "width", 100)
-	if w == 0 {
-		w = getFloat(data, "w", 100)
+// log — записать предупреждения отчёта в лог (по одному на слой)
+func (r *renderReport) log(postID string) {
+	for _, w := range r.Warnings {
+		slog.Warn("Post layer rendered with warning", "post_id", postID, "layer_id", w.LayerID, "type", w.Type, "reason", w.Reason, "err", w.Err)
 	}
-	h := getFloat(data, "height", 100)
-	if h == 0 {
-		h = getFloat(data, "h", 100)
+	if len(r.Warnings) > 0 {
+		slog.Warn("Post image rendered with warnings", "post_id", postID, "layers", r.Layers, "drawn", r.Drawn, "warnings", len(r.Warnings))
 	}
+}
 
-	// Парсим цвет
-	colorStr := getString(data, "color", "#ffffff")
-	c := parseColor(colorStr)
-
-	dc.SetColor(c)
-	dc.DrawRectangle(x, y, w, h)
-	dc.Fill()
+// notice — короткое сообщение пользователю о предупреждениях (пустое, если их нет)
+// Подробности (текст ошибок) — только в логе.
+func (r *renderReport) notice() string {
+	if len(r.Warnings) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("⚠️ Картинка собрана не полностью:")
+	for i, w := range r.Warnings {
+		if i == maxNoticeWarnings {
+			fmt.Fprintf(&b, "\n…и ещё %d", len(r.Warnings)-maxNoticeWarnings)
+			break
+		}
+		reason, ok := renderWarningReasons[w.Reason]
+		if !ok {
+			reason = w.Reason
+		}
+		if w.Type == "canvas" {
+			if w.Reason == "draw_failed" {
+				reason = "не удалось нарисовать фоновую картинку"
+			}
+			fmt.Fprintf(&b, "\n• холст: %s", reason)
+		} else if w.LayerID != "" {
+			fmt.Fprintf(&b, "\n• слой %s (%s): %s", w.LayerID, w.Type, reason)
+		} else {
+			fmt.Fprintf(&b, "\n• слой %s: %s", w.Type, reason)
+		}
+	}
+	return b.String()
 }
 
-// drawImage — накладывает изображение
-func drawImage(dc *gg.Context, data map[string]interface{}) error {
-	// Пробуем получить base64 изображение
-	imageBase64, ok := data["image_base64"].(string)
-	if !ok || imageBase64 == "" {
-		return fmt.Errorf("no image_base64 in layer data")
+// composeLayers — объединяет все слои в одно изображение
+// Слои, которые не удалось нарисовать (например, неизвестного типа), пропускаются и попадают в отчёт.
+// Холст — canvas из PostJSON (см. canvas.go); картинки слоёв по file_id скачиваются через bot.
+func composeLayers(ctx context.Context, bot *tgbotapi.BotAPI, canvas *agent.Canvas, layers []Layer) ([]byte, *renderReport, error) {
+	started := time.Now()
+	defer func() { composeDuration.Observe(time.Since(started).Seconds()) }()
+	report := &renderReport{Layers: len(layers)}
+
+	// Размеры canvas: из PostJSON или из настроек canvas (1080x1080 для квадратного поста),
+	// во втором случае холст растёт, чтобы вместить слои
+	spec := resolveCanvas(canvas, report)
+	if !spec.Explicit {
+		spec = fitCanvasToLayers(spec, layers)
 	}
+	canvasWidth, canvasHeight := spec.Width, spec.Height
 
-	// Декодируем base64
-	imageBytes, err := base64.StdEncoding.DecodeString(imageBase64)
-	if err != nil {
-		return fmt.Errorf("failed to decode base64: %w", err)
+	// Создаём canvas
+	dc := gg.NewContext(canvasWidth, canvasHeight)
+	dc.SetColor(parseColor(spec.Background)) // Фон (по умолчанию белый)
+	dc.Clear()
+	if len(spec.BackgroundImage) > 0 {
+		if err := drawCanvasBackground(ctx, bot, dc, spec); err != nil {
+			report.warn(Layer{LayerID: "canvas", Type: "canvas"}, "draw_failed", err)
+		}
 	}
 
-	// Декодируем изображение
-	img, _, err := image.Decode(bytes.NewReader(imageBytes))
-	if err != nil {
-		return fmt.Errorf("failed to decode image: %w", err)
+	// Начертания нужных размеров создаются по мере надобности и живут до конца сборки
+	faces := faceCache{}
+	defer faces.Close()
+
+	// Рисуем слои по порядку
+	// Слой с opacity или blend_mode рисуется на отдельном прозрачном холсте и затем накладывается (см. blend.go)
+	for _, layer := range layers {
+		opacity, mode, ok := layerBlend(layer.Data)
+		if !ok {
+			report.warn(layer, "unknown_blend_mode", fmt.Errorf("unknown blend mode %v, using normal", layer.Data["blend_mode"]))
+		}
+		if opacity == 0 {
+			continue
+		}
+
+		target := dc
+		if opacity < 1 || mode != "normal" {
+			target = gg.NewContext(canvasWidth, canvasHeight)
+		}
+		if err := drawLayer(ctx, bot, target, layer, faces); errors.Is(err, errUnknownLayerType) {
+			report.warn(layer, "unknown_type", err)
+			continue
+		} else if err != nil {
+			report.warn(layer, "draw_failed", err)
+			continue
+		}
+		if target != dc {
+			blendLayer(dc.Image().(*image.RGBA), target.Image().(*image.RGBA), opacity, mode)
+		}
+		report.Drawn++
 	}
 
-	x := getFloat(data, "x", 0)
-	y := getFloat(data, "y", 0)
-	scale := getFloat(data, "scale", 1.0)
-	// opacity := getFloat(data, "opacity", 1.0) // TODO: реализовать поддержку opacity
-
-	// Применяем масштаб и позицию
-	dc.Push()
-	
-	if scale != 1.0 {
-		// Перемещаемся в позицию
-		dc.Translate(x, y)
-		// Масштабируем
-		dc.Scale(scale, scale)
-		// Рисуем изображение в начале координат (после трансформации)
-		dc.DrawImage(img, 0, 0)
-	} else {
-		dc.DrawImage(img, int(x), int(y))
+	// Конвертируем в JPEG байты
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dc.Image(), &jpeg.Options{Quality: config.Canvas.JPEGQuality}); err != nil {
+		return nil, report, fmt.Errorf("failed to encode image: %w", err)
 	}
-	
-	dc.Pop()
-	
-	// Примечание: opacity пока не поддерживается библиотекой gg напрямую
-	// Можно реализовать через наложение полупрозрачного слоя, но это сложнее
 
-	return nil
+	return buf.Bytes(), report, nil
 }
 
-// drawText — рисует текст
-func drawText(dc *gg.Context, data map[string]interface{}) {
-	text := getString(data, "text", "")
-	if text == "" {
-		return
-	}
-
-	x := getFloat(data, "x", 0)
-	y := getFloat(data, "y", 0)
-	// fontSize := getFloat(data, "font_size", 48) // TODO: реализовать поддержку размера шрифта
-	colorStr := getString(data, "color", "#000000")
-	align := getString(data, "align", "left")
-
-	// Парсим цвет
-	c := parseColor(colorStr)
-	dc.SetColor(c)
-
-	// Устанавливаем шрифт
-	face := basicfont.Face7x13
-	dc.SetFontFace(face)
-	// Примечание: basicfont имеет фиксированный размер, для изменения размера нужен другой подход
-	// Пока используем базовый шрифт, можно расширить позже с truetype
-
-	// Выравнивание
-	switch align {
-	case "center":
-		dc.DrawStringAnchored(text, x, y, 0.5, 0.5)
-	case "right":
-		dc.DrawStringAnchored(text, x, y, 1, 0.5)
+// drawLayer — рисует слой по его типу
+func drawLayer(ctx context.Context, bot *tgbotapi.BotAPI, dc *gg.Context, layer Layer, faces faceCache) error {
+	switch layer.Type {
+	case "rectangle":
+		drawRectangle(dc, layer.Data)
+	case "rounded_rect":
+		drawRoundedRect(dc, layer.Data)
+	case "ellipse":
+		drawEllipse(dc, layer.Data)
+	case "line":
+		return drawLine(dc, layer.Data)
+	case "polygon":
+		return drawPolygon(dc, layer.Data)
+	case "gradient":
+		return drawGradient(dc, layer.Data)
+	case "image":
+		return drawImage(ctx, bot, dc, layer.Data)
+	case "text":
+		return drawText(dc, layer.Data, faces)
 	default:
-		dc.DrawString(text, x, y)
+		return errUnknownLayerType
 	}
+	return nil
 }
 
 // parseColor — парсит цвет из строки (#rrggbb или #rrggbbaa)
+// Альфа в #rrggbbaa — обычная (не premultiplied), поэтому color.NRGBA.
 func parseColor(colorStr string) color.Color {
 	colorStr = strings.TrimPrefix(colorStr, "#")
 	if len(colorStr) == 6 {
@@ -336,11 +277,21 @@ func parseColor(colorStr string) color.Color {
 		g, _ := strconv.ParseUint(colorStr[2:4], 16, 8)
 		b, _ := strconv.ParseUint(colorStr[4:6], 16, 8)
 		a, _ := strconv.ParseUint(colorStr[6:8], 16, 8)
-		return color.RGBA{uint8(r), uint8(g), uint8(b), uint8(a)}
+		return color.NRGBA{uint8(r), uint8(g), uint8(b), uint8(a)}
 	}
 	return color.Black
 }
 
+// isHexColor — цвет в формате #RRGGBB или #RRGGBBAA
+func isHexColor(colorStr string) bool {
+	colorStr = strings.TrimPrefix(colorStr, "#")
+	if len(colorStr) != 6 && len(colorStr) != 8 {
+		return false
+	}
+	_, err := strconv.ParseUint(colorStr, 16, 32)
+	return err == nil
+}
+
 // getFloat — безопасно получает float64 из map
 func getFloat(data map[string]interface{}, key string, defaultValue float64) float64 {
 	if val, ok := data[key]; ok {
@@ -368,24 +319,43 @@ func getString(data map[string]interface{}, key string, defaultValue string) str
 	return defaultValue
 }
 
+// getBool — безопасно получает bool из map
+func getBool(data map[string]interface{}, key string, defaultValue bool) bool {
+	if val, ok := data[key]; ok {
+		switch v := val.(type) {
+		case bool:
+			return v
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b
+			}
+		}
+	}
+	return defaultValue
+}
+
 // sendLayersSeparately — fallback: отправляет слои отдельно (старая логика)
-func sendLayersSeparately(chatID int64, layers []Layer, bot *tgbotapi.BotAPI) error {
+func sendLayersSeparately(ctx context.Context, chatID int64, layers []Layer, bot *tgbotapi.BotAPI) error {
 	for _, layer := range layers {
 		switch layer.Type {
 		case "image":
-			imageBase64, ok := layer.Data["image_base64"].(string)
-			if ok && imageBase64 != "" {
-				imageBytes, err := base64.StdEncoding.DecodeString(imageBase64)
-				if err != nil {
-					log.Printf("[ERROR] Failed to decode base64 image: %v", err)
+			// Файл из Telegram можно переслать по file_id, не скачивая
+			if getString(layer.Data, "image_base64", "") == "" && getString(layer.Data, "image_url", getString(layer.Data, "url", "")) == "" {
+				if fileID := getString(layer.Data, "file_id", ""); fileID != "" {
+					bot.Send(tgbotapi.NewPhoto(chatID, tgbotapi.FileID(fileID)))
 					continue
 				}
-				photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{
-					Name:  "image.jpg",
-					Bytes: imageBytes,
-				})
-				bot.Send(photo)
 			}
+			imageBytes, err := layerImageBytes(ctx, bot, layer.Data)
+			if err != nil {
+				slog.Error("Failed to load layer image", "layer_id", layer.LayerID, "err", err)
+				continue
+			}
+			photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{
+				Name:  "image.jpg",
+				Bytes: imageBytes,
+			})
+			bot.Send(photo)
 		case "text":
 			text, ok := layer.Data["text"].(string)
 			if ok {
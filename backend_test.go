@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRenderReportNotice(t *testing.T) {
+	failed := errors.New("boom")
+	tests := []struct {
+		name     string
+		warnings []renderWarning
+		want     string
+	}{
+		{"no warnings", nil, ""},
+		{"layers", []renderWarning{
+			{LayerID: "badge", Type: "star", Reason: "unknown_type", Err: errUnknownLayerType},
+			{Type: "text", Reason: "draw_failed", Err: failed},
+		}, "⚠️ Картинка собрана не полностью:\n• слой badge (star): неизвестный тип слоя, пропущен\n• слой text: не удалось нарисовать, пропущен"},
+		{"canvas", []renderWarning{
+			{LayerID: "canvas", Type: "canvas", Reason: "unknown_canvas_preset", Err: failed},
+			{LayerID: "canvas", Type: "canvas", Reason: "draw_failed", Err: failed},
+		}, "⚠️ Картинка собрана не полностью:\n• холст: неизвестный формат холста, использован размер по умолчанию\n• холст: не удалось нарисовать фоновую картинку"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := &renderReport{Warnings: tt.warnings}
+			if got := report.notice(); got != tt.want {
+				t.Errorf("notice() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("long report is cut", func(t *testing.T) {
+		report := &renderReport{}
+		for i := 0; i < maxNoticeWarnings+3; i++ {
+			report.Warnings = append(report.Warnings, renderWarning{Type: "star", Reason: "unknown_type"})
+		}
+		notice := report.notice()
+		if lines := strings.Count(notice, "• "); lines != maxNoticeWarnings {
+			t.Errorf("notice() lists %d warnings, want %d", lines, maxNoticeWarnings)
+		}
+		if !strings.HasSuffix(notice, "…и ещё 3") {
+			t.Errorf("notice() = %q, want a count of the rest", notice)
+		}
+	})
+}
+
+func TestComposeLayersReportsUnknownTypes(t *testing.T) {
+	layers := []Layer{
+		{LayerID: "bg", Type: "rectangle", Data: map[string]interface{}{"x": 0.0, "y": 0.0, "width": 10.0, "height": 10.0, "color": "#FF0000"}},
+		{LayerID: "badge", Type: "star", Data: map[string]interface{}{}},
+	}
+	_, report, err := composeLayers(context.Background(), nil, nil, layers)
+	if err != nil {
+		t.Fatalf("composeLayers() error = %v", err)
+	}
+	if report.Layers != 2 || report.Drawn != 1 {
+		t.Errorf("report = %d layers, %d drawn, want 2 and 1", report.Layers, report.Drawn)
+	}
+	if !strings.Contains(report.notice(), "слой badge (star): неизвестный тип слоя") {
+		t.Errorf("notice() = %q, want the skipped layer", report.notice())
+	}
+}
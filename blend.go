@@ -0,0 +1,71 @@
+// blend.go
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// blendFunc — смешивание канала слоя s с каналом фона b (значения 0..1, без учёта прозрачности)
+type blendFunc func(b, s float64) float64
+
+// blendModes — режимы наложения слоёв (blend_mode), формулы как в CSS mix-blend-mode
+var blendModes = map[string]blendFunc{
+	"normal":   func(b, s float64) float64 { return s },
+	"multiply": func(b, s float64) float64 { return b * s },
+	"screen":   func(b, s float64) float64 { return b + s - b*s },
+	"overlay": func(b, s float64) float64 {
+		if b <= 0.5 {
+			return 2 * b * s
+		}
+		return 1 - 2*(1-b)*(1-s)
+	},
+}
+
+// layerBlend — opacity (0..1) и blend_mode слоя
+// Неизвестный режим заменяется на normal (ok == false — чтобы предупредить в логе).
+func layerBlend(data map[string]interface{}) (opacity float64, mode string, ok bool) {
+	opacity = max(0, min(getFloat(data, "opacity", 1), 1))
+	mode = getString(data, "blend_mode", "normal")
+	if _, ok = blendModes[mode]; !ok {
+		mode = "normal"
+	}
+	return opacity, mode, ok
+}
+
+// blendLayer — накладывает отдельно нарисованный слой src на холст dst
+// с прозрачностью opacity и режимом mode (смешивание и source-over, как в CSS Compositing).
+// Оба изображения одного размера, с premultiplied alpha (как image.RGBA у gg).
+func blendLayer(dst, src *image.RGBA, opacity float64, mode string) {
+	blend := blendModes[mode]
+	if blend == nil {
+		blend = blendModes["normal"]
+	}
+	for y := 0; y < src.Rect.Dy(); y++ {
+		s := src.Pix[y*src.Stride : y*src.Stride+src.Rect.Dx()*4]
+		d := dst.Pix[y*dst.Stride : y*dst.Stride+dst.Rect.Dx()*4]
+		for i := 0; i < len(s) && i < len(d); i += 4 {
+			if s[i+3] == 0 {
+				continue
+			}
+			sa := float64(s[i+3]) / 255
+			as := sa * opacity
+			ab := float64(d[i+3]) / 255
+			for c := 0; c < 3; c++ {
+				cs := min(float64(s[i+c])/255/sa, 1) // Цвет слоя без premultiply
+				cb := 0.0
+				if ab > 0 {
+					cb = min(float64(d[i+c])/255/ab, 1)
+				}
+				mixed := (1-ab)*cs + ab*blend(cb, cs)
+				d[i+c] = toByte(as*mixed + (1-as)*ab*cb)
+			}
+			d[i+3] = toByte(as + ab*(1-as))
+		}
+	}
+}
+
+// toByte — значение 0..1 в байт канала
+func toByte(v float64) uint8 {
+	return uint8(math.Round(max(0, min(v, 1)) * 255))
+}
@@ -0,0 +1,140 @@
+// canvas.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"nko-bot-frontend/agent"
+
+	"github.com/fogleman/gg"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// canvasCallbackPrefix — callback кнопки выбора формата: canvas_<название>
+const canvasCallbackPrefix = "canvas_"
+
+// canvasPreset — готовый формат изображения поста
+type canvasPreset struct {
+	Name   string // Для PostJSON и callback'ов: canvas_<Name>
+	Title  string // Для кнопки выбора формата
+	Width  int
+	Height int
+}
+
+// canvasPresets — форматы в порядке кнопок выбора
+var canvasPresets = []canvasPreset{
+	{"square", "⬛ Квадрат 1:1", 1080, 1080},
+	{"portrait", "📱 Портрет 4:5", 1080, 1350},
+	{"story", "🎬 Сторис 9:16", 1080, 1920},
+	{"cover", "🖥 Обложка 16:9", 1920, 1080},
+	{"vk_post", "ВКонтакте: пост", 1000, 700},
+	{"vk_cover", "ВКонтакте: обложка", 1920, 768},
+	{"ok_post", "Одноклассники: пост", 1680, 1120},
+	{"ok_cover", "Одноклассники: обложка", 1944, 600},
+}
+
+// findCanvasPreset — формат по названию
+func findCanvasPreset(name string) (canvasPreset, bool) {
+	for _, preset := range canvasPresets {
+		if preset.Name == name {
+			return preset, true
+		}
+	}
+	return canvasPreset{}, false
+}
+
+// presetCanvas — холст для запроса к агенту по выбранному формату (nil — формат не выбран)
+func presetCanvas(name string) *agent.Canvas {
+	preset, ok := findCanvasPreset(name)
+	if !ok {
+		return nil
+	}
+	return &agent.Canvas{Preset: preset.Name, Width: preset.Width, Height: preset.Height}
+}
+
+// withRequestedCanvas — пост в выбранном пользователем формате, если агент не прислал свой canvas
+func withRequestedCanvas(post *PostJSON, err error, canvas *agent.Canvas) (*PostJSON, error) {
+	if err == nil && post != nil && post.Canvas == nil {
+		post.Canvas = canvas
+	}
+	return post, err
+}
+
+// canvasSpec — итоговые размеры и фон холста
+type canvasSpec struct {
+	Width           int
+	Height          int
+	Background      string
+	BackgroundImage map[string]interface{}
+	Explicit        bool // Размер задан в PostJSON — не подгоняем его под слои
+}
+
+// resolveCanvas — холст поста: настройки canvas, поверх них preset, width/height и background из PostJSON
+// Ошибки в canvas не мешают сборке: неверные значения заменяются настройками, а в отчёт пишется предупреждение.
+func resolveCanvas(canvas *agent.Canvas, report *renderReport) canvasSpec {
+	spec := canvasSpec{Width: config.Canvas.Width, Height: config.Canvas.Height, Background: config.Canvas.Background}
+	if canvas == nil {
+		return spec
+	}
+	warn := func(reason string, err error) {
+		report.warn(Layer{LayerID: "canvas", Type: "canvas"}, reason, err)
+	}
+
+	spec.Explicit = true
+	if canvas.Preset != "" {
+		if preset, ok := findCanvasPreset(canvas.Preset); ok {
+			spec.Width, spec.Height = preset.Width, preset.Height
+		} else {
+			warn("unknown_canvas_preset", fmt.Errorf("unknown canvas preset %q", canvas.Preset))
+		}
+	}
+	if canvas.Width != 0 || canvas.Height != 0 {
+		if canvas.Width > 0 && canvas.Width <= maxCanvasSide && canvas.Height > 0 && canvas.Height <= maxCanvasSide {
+			spec.Width, spec.Height = canvas.Width, canvas.Height
+		} else {
+			warn("bad_canvas_size", fmt.Errorf("canvas size must be between 1 and %d, got %dx%d", maxCanvasSide, canvas.Width, canvas.Height))
+		}
+	}
+	if canvas.Background != "" {
+		if isHexColor(canvas.Background) {
+			spec.Background = canvas.Background
+		} else {
+			warn("bad_canvas_background", fmt.Errorf("canvas background must be #RRGGBB or #RRGGBBAA, got %q", canvas.Background))
+		}
+	}
+	spec.BackgroundImage = canvas.BackgroundImage
+	return spec
+}
+
+// fitCanvasToLayers — старое поведение без canvas в PostJSON: холст растёт, чтобы вместить слои с размерами
+func fitCanvasToLayers(spec canvasSpec, layers []Layer) canvasSpec {
+	for _, layer := range layers {
+		x, y, w, h := layerBox(layer.Data, 0, 0)
+		if w > 0 {
+			spec.Width = max(spec.Width, int(math.Ceil(x+w)))
+		}
+		if h > 0 {
+			spec.Height = max(spec.Height, int(math.Ceil(y+h)))
+		}
+	}
+	spec.Width = min(spec.Width, maxCanvasSide)
+	spec.Height = min(spec.Height, maxCanvasSide)
+	return spec
+}
+
+// drawCanvasBackground — фоновая картинка холста: слой image на весь холст (по умолчанию fit: cover)
+func drawCanvasBackground(ctx context.Context, bot *tgbotapi.BotAPI, dc *gg.Context, spec canvasSpec) error {
+	data := map[string]interface{}{
+		"x":      0.0,
+		"y":      0.0,
+		"width":  float64(spec.Width),
+		"height": float64(spec.Height),
+		"fit":    "cover",
+	}
+	for key, value := range spec.BackgroundImage {
+		data[key] = value
+	}
+	return drawImage(ctx, bot, dc, data)
+}
@@ -0,0 +1,437 @@
+// config.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile — файл настроек по умолчанию (другой путь — CONFIG_FILE)
+const defaultConfigFile = "config.yaml"
+
+// Config — настройки бота
+// Загружаются один раз при запуске: значения по умолчанию → config.yaml → переменные окружения.
+// Полный пример с комментариями — config.example.yaml.
+type Config struct {
+	Telegram TelegramConfig `yaml:"telegram"`
+	Agent    AgentConfig    `yaml:"agent"`
+	Server   ServerConfig   `yaml:"server"`
+	Storage  StorageConfig  `yaml:"storage"`
+	States   StatesConfig   `yaml:"states"`
+	Jobs     JobsConfig     `yaml:"jobs"`
+	Canvas   CanvasConfig   `yaml:"canvas"`
+	Fonts    FontsConfig    `yaml:"fonts"`
+	Images   ImagesConfig   `yaml:"images"`
+	Features FeaturesConfig `yaml:"features"`
+	Log      LogConfig      `yaml:"log"`
+
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"` // Сколько ждать задачи при остановке
+}
+
+// TelegramConfig — подключение к Bot API
+type TelegramConfig struct {
+	Token string `yaml:"token"` // BOT_TOKEN
+	Debug bool   `yaml:"debug"` // Логировать запросы к Bot API (TELEGRAM_DEBUG)
+}
+
+// AgentConfig — клиент AI агента
+type AgentConfig struct {
+	URL              string        `yaml:"url"`               // AI_AGENT_URL
+	Secret           string        `yaml:"secret"`            // AI_AGENT_SECRET — подпись запросов (HMAC)
+	VerifyResponses  bool          `yaml:"verify_responses"`  // AI_AGENT_VERIFY_RESPONSES
+	Timeout          time.Duration `yaml:"timeout"`           // Таймаут генерации (одна попытка)
+	InitTimeout      time.Duration `yaml:"init_timeout"`      // Таймаут /api/auth/init
+	RetryAttempts    int           `yaml:"retry_attempts"`    // Всего попыток, включая первую
+	RetryBaseDelay   time.Duration `yaml:"retry_base_delay"`  // Пауза перед первым повтором
+	RetryMaxDelay    time.Duration `yaml:"retry_max_delay"`   // Максимальная пауза
+	BreakerThreshold int           `yaml:"breaker_threshold"` // Сбоев подряд до размыкания цепи (0 — отключить)
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`  // Сколько не обращаться к агенту после размыкания
+}
+
+// ServerConfig — получение обновлений и встроенный HTTP сервер
+type ServerConfig struct {
+	Mode                string `yaml:"mode"`                   // MODE: polling или webhook
+	Listen              string `yaml:"listen"`                 // HTTP_LISTEN
+	TLSCert             string `yaml:"tls_cert"`               // HTTP_TLS_CERT
+	TLSKey              string `yaml:"tls_key"`                // HTTP_TLS_KEY
+	WebhookURL          string `yaml:"webhook_url"`            // WEBHOOK_URL
+	WebhookPath         string `yaml:"webhook_path"`           // WEBHOOK_PATH
+	WebhookSecret       string `yaml:"webhook_secret"`         // WEBHOOK_SECRET
+	WebhookDeleteOnExit bool   `yaml:"webhook_delete_on_exit"` // WEBHOOK_DELETE_ON_EXIT
+}
+
+// StorageConfig — где хранить данные
+type StorageConfig struct {
+	StateStore     string        `yaml:"state_store"`     // STATE_STORE: memory или file
+	StatePath      string        `yaml:"state_path"`      // STATE_STORE_PATH
+	NKODataPath    string        `yaml:"nko_data_path"`   // NKO_DATA_PATH
+	Backups        int           `yaml:"backups"`         // Сколько резервных копий данных НКО хранить
+	BackupInterval time.Duration `yaml:"backup_interval"` // Как часто делать новую копию (первая — при первой записи после запуска)
+}
+
+// StatesConfig — время жизни незавершённых сценариев
+type StatesConfig struct {
+	FlowTTL         time.Duration            `yaml:"flow_ttl"`         // STATE_TTL
+	IdleTTL         time.Duration            `yaml:"idle_ttl"`         // STATE_IDLE_TTL
+	FlowTTLs        map[string]time.Duration `yaml:"flow_ttls"`        // STATE_TTL_<СЦЕНАРИЙ>
+	JanitorInterval time.Duration            `yaml:"janitor_interval"` // Как часто проверять состояния
+}
+
+// JobsConfig — фоновые задачи генерации
+type JobsConfig struct {
+	Workers   int `yaml:"workers"`    // Одновременно выполняемых задач
+	QueueSize int `yaml:"queue_size"` // Задач в очереди
+	PerUser   int `yaml:"per_user"`   // Задач одного пользователя в работе
+}
+
+// CanvasConfig — холст для сборки изображения поста из слоёв
+type CanvasConfig struct {
+	Width       int    `yaml:"width"`
+	Height      int    `yaml:"height"`
+	Background  string `yaml:"background"`   // Цвет фона, #RRGGBB
+	JPEGQuality int    `yaml:"jpeg_quality"` // 1–100
+}
+
+// FontsConfig — шрифты текстовых слоёв (встроенный шрифт Go есть всегда)
+type FontsConfig struct {
+	Dir           string   `yaml:"dir"`            // FONTS_DIR — каталог с .ttf/.otf/.ttc
+	DefaultFamily string   `yaml:"default_family"` // Семейство, если font_family слоя не задан или неизвестен
+	Fallback      []string `yaml:"fallback"`       // Где искать символы, которых нет в основном шрифте
+}
+
+// ImagesConfig — картинки слоёв по ссылке (image_url) и из Telegram (file_id)
+type ImagesConfig struct {
+	AllowedHosts  []string      `yaml:"allowed_hosts"`   // IMAGE_ALLOWED_HOSTS — откуда можно скачивать image_url (пусто — ниоткуда)
+	MaxBytes      int           `yaml:"max_bytes"`       // Предел размера одной картинки
+	FetchTimeout  time.Duration `yaml:"fetch_timeout"`   // Таймаут скачивания image_url
+	CacheDir      string        `yaml:"cache_dir"`       // IMAGE_CACHE_DIR — кэш скачанных картинок (пусто — без кэша)
+	CacheMaxBytes int           `yaml:"cache_max_bytes"` // Предел размера кэша (0 — без ограничения)
+}
+
+// FeaturesConfig — включение функций бота (выключенные пропадают из меню)
+type FeaturesConfig struct {
+	ImageGeneration bool `yaml:"image_generation"` // «Генерация картинки»
+	TextEditor      bool `yaml:"text_editor"`      // «Редактор текста»
+	ContentPlan     bool `yaml:"content_plan"`     // «Контент-план»
+	Workspaces      bool `yaml:"workspaces"`       // Команды: приглашения, общие посты и каналы
+}
+
+// LogConfig — логирование
+type LogConfig struct {
+	Level        string `yaml:"level"`         // LOG_LEVEL: debug, info, warn, error (меняется на лету через /loglevel)
+	Format       string `yaml:"format"`        // LOG_FORMAT: text или json
+	PayloadLimit int    `yaml:"payload_limit"` // Сколько байт тела запроса к агенту писать в лог (0 — без ограничения)
+	AdminToken   string `yaml:"admin_token"`   // LOG_ADMIN_TOKEN — доступ к /loglevel (пустой — endpoint выключен)
+}
+
+// config — настройки, загруженные при запуске (значения по умолчанию до вызова LoadConfig)
+var config = defaultConfig()
+
+// defaultConfig — значения по умолчанию
+func defaultConfig() Config {
+	return Config{
+		Agent: AgentConfig{
+			Timeout:          60 * time.Second,
+			InitTimeout:      10 * time.Second,
+			RetryAttempts:    3,
+			RetryBaseDelay:   500 * time.Millisecond,
+			RetryMaxDelay:    5 * time.Second,
+			BreakerThreshold: 5,
+			BreakerCooldown:  30 * time.Second,
+		},
+		Server: ServerConfig{
+			Mode:                modePolling,
+			WebhookDeleteOnExit: true,
+		},
+		Storage: StorageConfig{
+			StateStore:     "memory",
+			StatePath:      "user_states.json",
+			NKODataPath:    "nko_data.json",
+			Backups:        5,
+			BackupInterval: time.Hour,
+		},
+		States: StatesConfig{
+			FlowTTL:         30 * time.Minute,
+			IdleTTL:         24 * time.Hour,
+			FlowTTLs:        map[string]time.Duration{},
+			JanitorInterval: time.Minute,
+		},
+		Jobs: JobsConfig{
+			Workers:   4,
+			QueueSize: 100,
+			PerUser:   2,
+		},
+		Canvas: CanvasConfig{
+			Width:       1080,
+			Height:      1080,
+			Background:  "#FFFFFF",
+			JPEGQuality: 90,
+		},
+		Fonts: FontsConfig{
+			DefaultFamily: defaultFontFamily,
+		},
+		Images: ImagesConfig{
+			MaxBytes:      10 << 20,
+			FetchTimeout:  15 * time.Second,
+			CacheDir:      "image_cache",
+			CacheMaxBytes: 200 << 20,
+		},
+		Features: FeaturesConfig{
+			ImageGeneration: true,
+			TextEditor:      true,
+			ContentPlan:     true,
+			Workspaces:      true,
+		},
+		Log: LogConfig{
+			Level:        "info",
+			Format:       "text",
+			PayloadLimit: 2048,
+		},
+		ShutdownTimeout: 30 * time.Second,
+	}
+}
+
+// LoadConfig — загрузка и проверка настроек
+// Файл берётся из CONFIG_FILE (обязан существовать) или config.yaml (если есть).
+func LoadConfig() (Config, error) {
+	cfg := defaultConfig()
+
+	path, required := os.Getenv("CONFIG_FILE"), true
+	if path == "" {
+		path, required = defaultConfigFile, false
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		// Опечатка в имени настройки — ошибка, а не молча проигнорированное значение; io.EOF — пустой файл
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return cfg, fmt.Errorf("config %s: %w", path, err)
+		}
+	case !os.IsNotExist(err) || required:
+		return cfg, fmt.Errorf("config %s: %w", path, err)
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// applyEnv — переменные окружения поверх файла (имена те же, что были до появления config.yaml)
+func (c *Config) applyEnv() error {
+	var errs []string
+	str := func(name string, target *string) {
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			*target = value
+		}
+	}
+	boolean := func(name string, target *bool) {
+		value := os.Getenv(name)
+		if value == "" {
+			return
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s=%q: expected true or false", name, value))
+			return
+		}
+		*target = parsed
+	}
+	integer := func(name string, target *int) {
+		value := os.Getenv(name)
+		if value == "" {
+			return
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s=%q: expected an integer", name, value))
+			return
+		}
+		*target = parsed
+	}
+	duration := func(name string, target *time.Duration) {
+		value := os.Getenv(name)
+		if value == "" {
+			return
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s=%q: expected a duration like 30s or 5m", name, value))
+			return
+		}
+		*target = parsed
+	}
+
+	str("BOT_TOKEN", &c.Telegram.Token)
+	boolean("TELEGRAM_DEBUG", &c.Telegram.Debug)
+
+	str("AI_AGENT_URL", &c.Agent.URL)
+	str("AI_AGENT_SECRET", &c.Agent.Secret)
+	boolean("AI_AGENT_VERIFY_RESPONSES", &c.Agent.VerifyResponses)
+	duration("AI_AGENT_TIMEOUT", &c.Agent.Timeout)
+	duration("AI_AGENT_INIT_TIMEOUT", &c.Agent.InitTimeout)
+	integer("AI_AGENT_RETRY_ATTEMPTS", &c.Agent.RetryAttempts)
+
+	str("MODE", &c.Server.Mode)
+	str("HTTP_LISTEN", &c.Server.Listen)
+	str("HTTP_TLS_CERT", &c.Server.TLSCert)
+	str("HTTP_TLS_KEY", &c.Server.TLSKey)
+	str("WEBHOOK_URL", &c.Server.WebhookURL)
+	str("WEBHOOK_PATH", &c.Server.WebhookPath)
+	str("WEBHOOK_SECRET", &c.Server.WebhookSecret)
+	boolean("WEBHOOK_DELETE_ON_EXIT", &c.Server.WebhookDeleteOnExit)
+
+	str("STATE_STORE", &c.Storage.StateStore)
+	str("STATE_STORE_PATH", &c.Storage.StatePath)
+	str("NKO_DATA_PATH", &c.Storage.NKODataPath)
+
+	duration("STATE_TTL", &c.States.FlowTTL)
+	duration("STATE_IDLE_TTL", &c.States.IdleTTL)
+	if c.States.FlowTTLs == nil {
+		c.States.FlowTTLs = map[string]time.Duration{}
+	}
+	for flow := range flowTitles {
+		name := "STATE_TTL_" + strings.ToUpper(flow)
+		if os.Getenv(name) == "" {
+			continue
+		}
+		ttl := c.States.FlowTTLs[flow]
+		duration(name, &ttl)
+		c.States.FlowTTLs[flow] = ttl
+	}
+
+	str("FONTS_DIR", &c.Fonts.Dir)
+	if hosts := os.Getenv("IMAGE_ALLOWED_HOSTS"); hosts != "" {
+		c.Images.AllowedHosts = strings.FieldsFunc(hosts, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+	if dir, ok := os.LookupEnv("IMAGE_CACHE_DIR"); ok {
+		c.Images.CacheDir = dir // Пустое значение выключает кэш
+	}
+
+	integer("JOB_WORKERS", &c.Jobs.Workers)
+	integer("JOB_QUEUE_SIZE", &c.Jobs.QueueSize)
+	integer("JOB_PER_USER", &c.Jobs.PerUser)
+
+	str("LOG_LEVEL", &c.Log.Level)
+	str("LOG_FORMAT", &c.Log.Format)
+	integer("LOG_PAYLOAD_LIMIT", &c.Log.PayloadLimit)
+	str("LOG_ADMIN_TOKEN", &c.Log.AdminToken)
+	duration("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+
+	if len(errs) > 0 {
+		return errors.New("config: invalid environment variables:\n  - " + strings.Join(errs, "\n  - "))
+	}
+	return nil
+}
+
+// Validate — проверка настроек; в ошибке перечислены все найденные проблемы
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Telegram.Token != "", "telegram.token (BOT_TOKEN) is required")
+
+	if c.Agent.URL != "" {
+		u, err := url.Parse(c.Agent.URL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"agent.url (AI_AGENT_URL) must be an http(s) URL, got %q", c.Agent.URL)
+	}
+	check(c.Agent.Timeout > 0, "agent.timeout must be positive")
+	check(c.Agent.InitTimeout > 0, "agent.init_timeout must be positive")
+	check(c.Agent.RetryAttempts >= 1, "agent.retry_attempts must be at least 1")
+	check(c.Agent.RetryBaseDelay >= 0 && c.Agent.RetryMaxDelay >= c.Agent.RetryBaseDelay,
+		"agent.retry_base_delay must be non-negative and not exceed agent.retry_max_delay")
+	check(c.Agent.BreakerThreshold >= 0, "agent.breaker_threshold must not be negative")
+	check(c.Agent.BreakerThreshold == 0 || c.Agent.BreakerCooldown > 0, "agent.breaker_cooldown must be positive")
+	check(!c.Agent.VerifyResponses || c.Agent.Secret != "", "agent.verify_responses requires agent.secret (AI_AGENT_SECRET)")
+
+	switch c.Server.Mode {
+	case modePolling:
+	case modeWebhook:
+		u, err := url.Parse(c.Server.WebhookURL)
+		check(c.Server.WebhookURL != "", "server.webhook_url (WEBHOOK_URL) is required for mode webhook")
+		check(c.Server.WebhookURL == "" || (err == nil && u.Scheme == "https" && u.Host != ""),
+			"server.webhook_url (WEBHOOK_URL) must be an https URL, got %q", c.Server.WebhookURL)
+	default:
+		check(false, "server.mode (MODE) must be %s or %s, got %q", modePolling, modeWebhook, c.Server.Mode)
+	}
+	check((c.Server.TLSCert == "") == (c.Server.TLSKey == ""), "server.tls_cert and server.tls_key must be set together")
+	check(c.Server.WebhookPath == "" || webhookPathPattern.MatchString(c.Server.WebhookPath),
+		"server.webhook_path (WEBHOOK_PATH) must start with / and contain only A-Z, a-z, 0-9 and ._~/-, got %q", c.Server.WebhookPath)
+	check(!slices.Contains(reservedHTTPPaths, strings.TrimRight(c.Server.WebhookPath, "/")),
+		"server.webhook_path (WEBHOOK_PATH) must not be one of %s", strings.Join(reservedHTTPPaths, ", "))
+	check(c.Server.WebhookSecret == "" || webhookSecretPattern.MatchString(c.Server.WebhookSecret),
+		"server.webhook_secret (WEBHOOK_SECRET) may contain only A-Z, a-z, 0-9, _ and - (up to 256 characters)")
+
+	check(c.Storage.StateStore == "memory" || c.Storage.StateStore == "file",
+		"storage.state_store (STATE_STORE) must be memory or file, got %q", c.Storage.StateStore)
+	check(c.Storage.StateStore != "file" || c.Storage.StatePath != "", "storage.state_path (STATE_STORE_PATH) is required for state_store file")
+	check(c.Storage.NKODataPath != "", "storage.nko_data_path (NKO_DATA_PATH) is required")
+	check(c.Storage.Backups >= 0, "storage.backups must not be negative")
+	check(c.Storage.BackupInterval > 0, "storage.backup_interval must be positive")
+
+	check(c.States.FlowTTL > 0, "states.flow_ttl (STATE_TTL) must be positive")
+	check(c.States.IdleTTL > 0, "states.idle_ttl (STATE_IDLE_TTL) must be positive")
+	check(c.States.JanitorInterval > 0, "states.janitor_interval must be positive")
+	for flow, ttl := range c.States.FlowTTLs {
+		_, known := flowTitles[flow]
+		check(known, "states.flow_ttls: unknown flow %q", flow)
+		check(ttl > 0, "states.flow_ttls.%s must be positive", flow)
+	}
+
+	check(c.Jobs.Workers >= 1, "jobs.workers (JOB_WORKERS) must be at least 1")
+	check(c.Jobs.QueueSize >= 1, "jobs.queue_size (JOB_QUEUE_SIZE) must be at least 1")
+	check(c.Jobs.PerUser >= 1, "jobs.per_user (JOB_PER_USER) must be at least 1")
+
+	check(c.Canvas.Width > 0 && c.Canvas.Width <= maxCanvasSide && c.Canvas.Height > 0 && c.Canvas.Height <= maxCanvasSide,
+		"canvas.width and canvas.height must be between 1 and %d", maxCanvasSide)
+	check(isHexColor(c.Canvas.Background), "canvas.background must be a #RRGGBB color, got %q", c.Canvas.Background)
+	check(c.Canvas.JPEGQuality >= 1 && c.Canvas.JPEGQuality <= 100, "canvas.jpeg_quality must be between 1 and 100")
+
+	check(c.Fonts.DefaultFamily != "", "fonts.default_family is required")
+	if c.Fonts.Dir != "" {
+		info, err := os.Stat(c.Fonts.Dir)
+		check(err == nil && info.IsDir(), "fonts.dir (FONTS_DIR) %q is not a directory", c.Fonts.Dir)
+	}
+
+	for _, host := range c.Images.AllowedHosts {
+		check(host != "" && !strings.ContainsAny(host, "/:"),
+			"images.allowed_hosts (IMAGE_ALLOWED_HOSTS) must contain host names like example.com or *.example.com, got %q", host)
+	}
+	check(c.Images.MaxBytes > 0, "images.max_bytes must be positive")
+	check(c.Images.FetchTimeout > 0, "images.fetch_timeout must be positive")
+	check(c.Images.CacheMaxBytes >= 0, "images.cache_max_bytes must not be negative")
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		check(false, "log.level (LOG_LEVEL) must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format (LOG_FORMAT) must be text or json, got %q", c.Log.Format)
+	check(c.Log.PayloadLimit >= 0, "log.payload_limit (LOG_PAYLOAD_LIMIT) must not be negative")
+	check(c.ShutdownTimeout > 0, "shutdown_timeout (SHUTDOWN_TIMEOUT) must be positive")
+
+	if len(errs) > 0 {
+		return errors.New("config: invalid settings:\n  - " + strings.Join(errs, "\n  - "))
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// flows.go
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"nko-bot-frontend/agent"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// nkoStyle — стиль постов НКО
+type nkoStyle struct {
+	Callback    string
+	Label       string
+	Value       string // Значение в NKOData.Style (по нему buildPrompt выбирает инструкцию)
+	Description string // Пояснение для подтверждения
+}
+
+// nkoStyles — стили постов (рекомендация ТЗ для креатива)
+var nkoStyles = []nkoStyle{
+	{"style_conversational", "Разговорный", "разговорный", "в разговорном, живом стиле"},
+	{"style_formal", "Официальный", "официальный", "в официальном, деловом стиле"},
+	{"style_artistic", "Художественный", "художественный", "в художественном, образном стиле"},
+	{"style_emotional", "Эмоциональный", "эмоциональный", "в эмоциональном, вдохновляющем стиле"},
+	{"style_informational", "Информационный", "информационный", "в информационном, новостном стиле"},
+	{"style_call_to_action", "Призыв к действию", "призыв к действию", "в стиле призыва к действию, мотивирующем и побуждающем"},
+	{"style_gratitude", "Благодарственный", "благодарственный", "в благодарственном, тёплом стиле"},
+	{"style_friendly", "Дружелюбный", "дружелюбный", "в дружелюбном, неформальном стиле"},
+}
+
+func init() {
+	registerWizard(nkoWizard())
+	registerWizard(textStructWizard())
+	registerWizard(contentPlanWizard())
+}
+
+// nkoWizard — ввод (или обновление) данных НКО
+func nkoWizard() *Wizard {
+	styleOptions := make([]WizardOption, 0, len(nkoStyles))
+	for _, style := range nkoStyles {
+		styleOptions = append(styleOptions, WizardOption{Label: style.Label, Callback: style.Callback, Value: style.Value})
+	}
+
+	return &Wizard{
+		ID: "nko",
+		Steps: []WizardStep{
+			{Key: "name", Prompt: "🏷️ Введи название твоей НКО:", Validate: requireText("Название НКО не может быть пустым")},
+			{Key: "desc", Prompt: "📝 Опиши свою НКО:\n\nРасскажи, чем занимается твоя организация, какие цели и задачи она решает."},
+			{Key: "activities", Prompt: "🎯 Укажи формы деятельности НКО:\n\nНапример: помощь бездомным, экологические проекты, образовательные программы и т.д."},
+			{Key: "style", Prompt: "✨ Выбери стиль постов для твоей НКО:\n\nСтиль влияет на тон и подачу контента. Выбери наиболее подходящий вариант.", Options: styleOptions},
+		},
+		// При обновлении показываем текущие значения — их можно оставить кнопкой
+		Start: func(state *UserState) {
+			state.TempData["name"] = state.NKO.Name
+			state.TempData["desc"] = state.NKO.Description
+			state.TempData["activities"] = state.NKO.Activities
+			state.TempData["style"] = state.NKO.Style
+		},
+		// Профиль записывается только здесь: состояния диалогов хранят лишь его копию
+		Finish: func(ctx context.Context, state *UserState, bot *tgbotapi.BotAPI) {
+			chatID := state.ChatID
+			nko := NKOData{
+				Name:        state.TempData["name"],
+				Description: state.TempData["desc"],
+				Activities:  state.TempData["activities"],
+				Style:       state.TempData["style"],
+			}
+			_, err := SaveNKOData(chatID, state.ProfileID, nko)
+			ResetUserState(chatID)
+			if err != nil {
+				requestLogger(ctx).Error("Ошибка сохранения данных НКО", "chat_id", chatID, "err", err)
+				msg := tgbotapi.NewMessage(chatID, "❌ Не удалось сохранить данные НКО: "+err.Error())
+				msg.ReplyMarkup = MainMenu(ActiveProfileTitle(chatID))
+				bot.Send(msg)
+				return
+			}
+
+			text := "✅ Данные НКО сохранены."
+			for _, style := range nkoStyles {
+				if style.Value == nko.Style {
+					text = "✅ Стиль постов сохранён: " + style.Label + "\n\nТеперь все посты будут создаваться " + style.Description + "."
+				}
+			}
+			msg := tgbotapi.NewMessage(chatID, text+" Выбери функцию из меню:")
+			msg.ReplyMarkup = MainMenu(ActiveProfileTitle(chatID))
+			bot.Send(msg)
+		},
+	}
+}
+
+// textStructWizard — структурированная форма генерации текста о событии
+func textStructWizard() *Wizard {
+	return &Wizard{
+		ID: "text_struct",
+		Steps: []WizardStep{
+			{Key: "event", Prompt: "📌 Опиши событие (что за мероприятие, повод и т.д.):", Validate: requireText("Опиши событие хотя бы парой слов")},
+			{Key: "date", Prompt: "📅 Укажи дату события:\n\nНапример: 25 декабря 2024 или 25.12.2024"},
+			{Key: "location", Prompt: "📍 Укажи место проведения события:\n\nНапример: Москва, концертный зал или онлайн"},
+			{Key: "invited", Prompt: "👥 Кто приглашён на событие?\n\nОпиши аудиторию или спикеров. Например: известные музыканты, волонтёры, эксперты и т.д."},
+			{Key: "details", Prompt: "📝 Дополнительные детали:\n\nУкажи программу мероприятия, условия участия, контакты и другую важную информацию."},
+		},
+		Finish: func(ctx context.Context, state *UserState, bot *tgbotapi.BotAPI) {
+			chatID := state.ChatID
+			// Формируем промпт на основе всех собранных данных
+			prompt := buildPrompt("structured", "", "", state.NKO, state.TempData)
+			ResetUserState(chatID)
+			enqueueTextJob(ctx, chatID, agent.GenerateTextRequest{Prompt: prompt, NKO: agentNKO(state.NKO)}, bot)
+		},
+	}
+}
+
+// contentPlanWizard — период и частота публикаций для контент-плана
+func contentPlanWizard() *Wizard {
+	return &Wizard{
+		ID: "plan",
+		Steps: []WizardStep{
+			{
+				Key:    "days",
+				Prompt: "📅 На сколько дней создать контент-план?\n\nВыбери готовый вариант или введи своё число от 1 до 365.",
+				Options: []WizardOption{
+					{Label: "7 дней", Callback: "plan_7", Value: "7"},
+					{Label: "14 дней", Callback: "plan_14", Value: "14"},
+					{Label: "30 дней", Callback: "plan_30", Value: "30"},
+				},
+				Columns:  3,
+				FreeText: true,
+				Validate: func(input string) (string, error) {
+					daysNum, err := strconv.Atoi(input)
+					if err != nil || daysNum < 1 || daysNum > 365 {
+						return "", errors.New("Введи число от 1 до 365")
+					}
+					return strconv.Itoa(daysNum), nil
+				},
+			},
+			{
+				Key:    "frequency",
+				Prompt: "📊 Как часто публиковать посты?\n\nВыбери подходящую частоту публикаций для контент-плана:",
+				Options: []WizardOption{
+					{Label: "Ежедневно", Callback: "freq_daily", Value: "ежедневно"},
+					{Label: "Через день", Callback: "freq_every_other", Value: "через день"},
+					{Label: "2 раза в неделю", Callback: "freq_twice_week", Value: "2 раза в неделю"},
+					{Label: "3 раза в неделю", Callback: "freq_thrice_week", Value: "3 раза в неделю"},
+				},
+			},
+		},
+		Finish: func(ctx context.Context, state *UserState, bot *tgbotapi.BotAPI) {
+			processContentPlan(ctx, state.ChatID, state.TempData["days"], state.TempData["frequency"], state, bot)
+		},
+	}
+}
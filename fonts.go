@@ -0,0 +1,387 @@
+// fonts.go
+package main
+
+import (
+	"fmt"
+	"image"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	defaultFontFamily = "go" // Встроенный шрифт Go: латиница, кириллица, греческий
+	defaultFontSize   = 48.0
+	defaultFontWeight = 400
+	maxFontSize       = 1000.0
+)
+
+// fontAliases — общие названия семейств, которые агент может прислать вместо конкретного шрифта
+var fontAliases = map[string]string{
+	"sans":       "go",
+	"sans-serif": "go",
+	"mono":       "go mono",
+	"monospace":  "go mono",
+}
+
+// fontWeightNames — начертания из имени шрифта (subfamily) и из font_weight слоя
+var fontWeightNames = map[string]int{
+	"thin":       100,
+	"hairline":   100,
+	"extralight": 200,
+	"ultralight": 200,
+	"light":      300,
+	"regular":    400,
+	"normal":     400,
+	"book":       400,
+	"roman":      400,
+	"medium":     500,
+	"semibold":   600,
+	"demibold":   600,
+	"bold":       700,
+	"extrabold":  800,
+	"ultrabold":  800,
+	"black":      900,
+	"heavy":      900,
+}
+
+// fontRegistry — шрифты, доступные текстовым слоям: семейство → начертание (100–900) → шрифт
+// Встроенные шрифты Go есть всегда, остальные загружаются из fonts.dir (loadFonts).
+type fontRegistry struct {
+	mu       sync.RWMutex
+	families map[string]map[int]*opentype.Font
+	fallback []string // Семейства, в которых ищется символ, отсутствующий в основном шрифте
+}
+
+// fonts — реестр шрифтов (заполняется в loadFonts при запуске)
+var fonts = newFontRegistry()
+
+// newFontRegistry — реестр со встроенными шрифтами Go
+func newFontRegistry() *fontRegistry {
+	r := &fontRegistry{families: make(map[string]map[int]*opentype.Font)}
+	bundled := []struct {
+		family string
+		weight int
+		ttf    []byte
+	}{
+		{"go", 400, goregular.TTF},
+		{"go", 500, gomedium.TTF},
+		{"go", 700, gobold.TTF},
+		{"go mono", 400, gomono.TTF},
+		{"go mono", 700, gomonobold.TTF},
+	}
+	for _, b := range bundled {
+		f, err := opentype.Parse(b.ttf)
+		if err != nil {
+			panic(fmt.Sprintf("bundled font %s %d: %v", b.family, b.weight, err))
+		}
+		r.add(b.family, b.weight, f)
+	}
+	r.fallback = []string{defaultFontFamily}
+	return r
+}
+
+// add — зарегистрировать шрифт (первый зарегистрированный вариант начертания не перезаписывается)
+func (r *fontRegistry) add(family string, weight int, f *opentype.Font) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	family = strings.ToLower(strings.TrimSpace(family))
+	if r.families[family] == nil {
+		r.families[family] = make(map[int]*opentype.Font)
+	}
+	if _, exists := r.families[family][weight]; exists {
+		return false
+	}
+	r.families[family][weight] = f
+	return true
+}
+
+// has — есть ли семейство (с учётом псевдонимов)
+func (r *fontRegistry) has(family string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.families[r.resolveLocked(family)]
+	return ok
+}
+
+// resolveLocked — название семейства в реестре (нижний регистр, псевдонимы раскрыты)
+func (r *fontRegistry) resolveLocked(family string) string {
+	family = strings.ToLower(strings.TrimSpace(family))
+	if _, ok := r.families[family]; !ok {
+		if alias, ok := fontAliases[family]; ok {
+			return alias
+		}
+	}
+	return family
+}
+
+// lookup — шрифт семейства с ближайшим к weight начертанием (nil, если семейства нет)
+// При равном расстоянии для weight >= 400 выбирается более жирное начертание, иначе более светлое.
+func (r *fontRegistry) lookup(family string, weight int) *opentype.Font {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	variants := r.families[r.resolveLocked(family)]
+	var best *opentype.Font
+	bestWeight := 0
+	for w, f := range variants {
+		if best == nil || closerWeight(weight, w, bestWeight) {
+			best, bestWeight = f, w
+		}
+	}
+	return best
+}
+
+// closerWeight — ближе ли начертание candidate к нужному weight, чем current
+func closerWeight(weight, candidate, current int) bool {
+	dc, dr := abs(candidate-weight), abs(current-weight)
+	if dc != dr {
+		return dc < dr
+	}
+	if weight >= 400 {
+		return candidate > current
+	}
+	return candidate < current
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// chain — шрифты для текста: нужное семейство, затем fallback-семейства (без повторов)
+// Неизвестное семейство заменяется семейством по умолчанию.
+func (r *fontRegistry) chain(family string, weight int) []*opentype.Font {
+	r.mu.RLock()
+	families := append([]string{family, config.Fonts.DefaultFamily}, r.fallback...)
+	r.mu.RUnlock()
+	var chain []*opentype.Font
+	seen := make(map[*opentype.Font]bool)
+	for _, name := range families {
+		if f := r.lookup(name, weight); f != nil && !seen[f] {
+			seen[f] = true
+			chain = append(chain, f)
+		}
+	}
+	return chain
+}
+
+// familyNames — зарегистрированные семейства (для лога)
+func (r *fontRegistry) familyNames() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadFonts — загрузить шрифты из fonts.dir (.ttf, .otf, .ttc, .otc) и задать цепочку fallback
+// Семейство и начертание берутся из таблицы имён шрифта. Нечитаемые файлы пропускаются с предупреждением.
+func loadFonts(cfg FontsConfig) error {
+	if cfg.Dir != "" {
+		entries, err := os.ReadDir(cfg.Dir)
+		if err != nil {
+			return fmt.Errorf("fonts dir: %w", err)
+		}
+		for _, entry := range entries {
+			ext := strings.ToLower(filepath.Ext(entry.Name()))
+			if entry.IsDir() || (ext != ".ttf" && ext != ".otf" && ext != ".ttc" && ext != ".otc") {
+				continue
+			}
+			path := filepath.Join(cfg.Dir, entry.Name())
+			if err := loadFontFile(path); err != nil {
+				slog.Warn("Failed to load font", "file", path, "err", err)
+			}
+		}
+	}
+
+	for _, family := range append([]string{cfg.DefaultFamily}, cfg.Fallback...) {
+		if !fonts.has(family) {
+			return fmt.Errorf("fonts: unknown family %q (available: %s)", family, strings.Join(fonts.familyNames(), ", "))
+		}
+	}
+	fonts.mu.Lock()
+	fonts.fallback = append(append([]string{}, cfg.Fallback...), defaultFontFamily)
+	fonts.mu.Unlock()
+	slog.Info("Fonts loaded", "families", strings.Join(fonts.familyNames(), ", "))
+	return nil
+}
+
+// loadFontFile — зарегистрировать шрифты из файла (в коллекции .ttc их может быть несколько)
+func loadFontFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	collection, err := opentype.ParseCollection(data)
+	if err != nil {
+		return err
+	}
+	var buf sfnt.Buffer
+	for i := 0; i < collection.NumFonts(); i++ {
+		f, err := collection.Font(i)
+		if err != nil {
+			return err
+		}
+		family, subfamily := fontNames(f, &buf)
+		if family == "" {
+			return fmt.Errorf("font %d has no family name", i)
+		}
+		if strings.Contains(strings.ToLower(subfamily), "italic") || strings.Contains(strings.ToLower(subfamily), "oblique") {
+			continue // Курсив текстовые слои пока не выбирают
+		}
+		weight := parseFontWeight(subfamily, defaultFontWeight)
+		if fonts.add(family, weight, f) {
+			slog.Debug("Font registered", "family", family, "weight", weight, "file", path)
+		}
+	}
+	return nil
+}
+
+// fontNames — семейство и начертание из таблицы имён (типографские имена, если есть)
+func fontNames(f *opentype.Font, buf *sfnt.Buffer) (family, subfamily string) {
+	family, _ = f.Name(buf, sfnt.NameIDTypographicFamily)
+	subfamily, _ = f.Name(buf, sfnt.NameIDTypographicSubfamily)
+	if family == "" {
+		family, _ = f.Name(buf, sfnt.NameIDFamily)
+		subfamily, _ = f.Name(buf, sfnt.NameIDSubfamily)
+	}
+	return family, subfamily
+}
+
+// parseFontWeight — начертание из числа (700) или названия (Bold, Semi Bold)
+func parseFontWeight(value string, defaultWeight int) int {
+	value = strings.ToLower(strings.NewReplacer(" ", "", "-", "", "_", "").Replace(value))
+	if n, err := strconv.Atoi(value); err == nil && n >= 1 && n <= 1000 {
+		return n
+	}
+	if weight, ok := fontWeightNames[value]; ok {
+		return weight
+	}
+	// «Bold Italic», «SemiBold Condensed» и т.п.
+	best := ""
+	for name := range fontWeightNames {
+		if strings.Contains(value, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best != "" {
+		return fontWeightNames[best]
+	}
+	return defaultWeight
+}
+
+// layerFontWeight — font_weight слоя: число или название
+func layerFontWeight(data map[string]interface{}) int {
+	switch v := data["font_weight"].(type) {
+	case float64:
+		return parseFontWeight(strconv.Itoa(int(v)), defaultFontWeight)
+	case string:
+		return parseFontWeight(v, defaultFontWeight)
+	}
+	return defaultFontWeight
+}
+
+// faceCache — начертания нужных размеров для одной сборки изображения
+// font.Face из opentype нельзя использовать из нескольких горутин, поэтому кэш живёт одну сборку.
+type faceCache map[faceKey]font.Face
+
+type faceKey struct {
+	font *opentype.Font
+	size float64
+}
+
+// face — текстовый шрифт семейства family нужного размера с цепочкой fallback
+func (c faceCache) face(family string, weight int, size float64) (font.Face, error) {
+	chain := fonts.chain(family, weight)
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no fonts available")
+	}
+	faces := make([]font.Face, len(chain))
+	for i, f := range chain {
+		key := faceKey{f, size}
+		face, ok := c[key]
+		if !ok {
+			var err error
+			face, err = opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone})
+			if err != nil {
+				return nil, err
+			}
+			c[key] = face
+		}
+		faces[i] = face
+	}
+	if len(faces) == 1 {
+		return faces[0], nil
+	}
+	return &fallbackFace{fonts: chain, faces: faces}, nil
+}
+
+// Close — освободить начертания
+func (c faceCache) Close() {
+	for _, face := range c {
+		face.Close()
+	}
+}
+
+// fallbackFace — font.Face, который берёт символ из первого шрифта цепочки, где он есть
+// Метрики строки — от основного шрифта. Если символа нет нигде, рисуется «пустой» глиф основного.
+type fallbackFace struct {
+	fonts []*opentype.Font
+	faces []font.Face
+	buf   sfnt.Buffer
+}
+
+// pick — начертание, в котором есть символ r
+func (f *fallbackFace) pick(r rune) font.Face {
+	for i, fnt := range f.fonts {
+		if index, err := fnt.GlyphIndex(&f.buf, r); err == nil && index != 0 {
+			return f.faces[i]
+		}
+	}
+	return f.faces[0]
+}
+
+func (f *fallbackFace) Close() error { return nil } // Начертания закрывает faceCache
+
+func (f *fallbackFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return f.pick(r).Glyph(dot, r)
+}
+
+func (f *fallbackFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return f.pick(r).GlyphBounds(r)
+}
+
+func (f *fallbackFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return f.pick(r).GlyphAdvance(r)
+}
+
+func (f *fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	face := f.pick(r0)
+	if face != f.pick(r1) {
+		return 0
+	}
+	return face.Kern(r0, r1)
+}
+
+func (f *fallbackFace) Metrics() font.Metrics {
+	return f.faces[0].Metrics()
+}
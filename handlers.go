@@ -1,24 +1,40 @@
 package main // для теста, но в проекте — без main
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
-	"log"
+	"net/http"
 	"strconv"
+	"strings"
+	"time"
+
+	"nko-bot-frontend/agent"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+// featureDisabledText — ответ на обращение к функции, выключенной в настройках features
+const featureDisabledText = "🚫 Эта функция сейчас отключена."
+
+// imageDescPrompt — просьба описать картинку (после выбора формата или вместо него)
+const imageDescPrompt = "🎨 Опиши картинку, которую нужно создать, или прикрепи изображение для обработки:\n\n💡 Чем подробнее описание, тем лучше результат!"
+
 // HandleUpdate — основная обработка (вызывается из main.go)
-func HandleUpdate(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+func HandleUpdate(ctx context.Context, update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	// Новый ID запроса на каждое обновление: он попадёт в логи, в задачи и в запросы к AI агенту
+	ctx = agent.WithRequestID(ctx, agent.NewRequestID())
+	countUpdate(update)
+	requestLogger(ctx).Debug("Update received", "update_id", update.UpdateID, "type", updateType(update))
 	if update.Message != nil {
-		handleMessage(update.Message, bot)
+		handleMessage(ctx, update.Message, bot)
 	} else if update.CallbackQuery != nil {
-		handleCallback(update.CallbackQuery, bot)
+		handleCallback(ctx, update.CallbackQuery, bot)
 	}
 }
 
 // handleMessage — обработка текстовых сообщений и команд
-func handleMessage(message *tgbotapi.Message, bot *tgbotapi.BotAPI) {
+func handleMessage(ctx context.Context, message *tgbotapi.Message, bot *tgbotapi.BotAPI) {
 	chatID := message.Chat.ID
 	text := message.Text
 
@@ -31,41 +47,51 @@ func handleMessage(message *tgbotapi.Message, bot *tgbotapi.BotAPI) {
 			photo := message.Photo[len(message.Photo)-1]
 			fileID := photo.FileID
 
-			// Получаем информацию о файле
-			file, err := bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
-			if err != nil {
-				bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка загрузки файла: "+err.Error()+"\n\nПопробуй отправить изображение ещё раз."))
-				return
-			}
-
-			// Формируем URL файла
-			fileURL := file.Link(bot.Token)
-
 			// Если есть подпись к фото, используем её как описание
 			desc := message.Caption
 			if desc == "" {
 				desc = "Обработать изображение"
 			}
 
-			data := map[string]interface{}{
-				"desc":      desc,
-				"image_url": fileURL,
-				"file_id":   fileID,
-				"nko":       state.NKO,
-			}
-
-			post, err := CallBackend("/generate_image", data, chatID)
-			if err != nil {
-				bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка генерации изображения: "+err.Error()+"\n\nПопробуй ещё раз или измени описание."))
-			} else {
-				SendPostToUser(chatID, post, bot)
-				msg := tgbotapi.NewMessage(chatID, "✨ Готово! Выбери действие с постом:")
-				msg.ReplyMarkup = PostActionInline(post.PostID)
-				bot.Send(msg)
-			}
+			req := agent.GenerateImageRequest{Desc: desc, FileID: fileID, Canvas: presetCanvas(state.TempData["canvas"]), NKO: agentNKO(state.NKO)}
 			ResetUserState(chatID)
+			// Фото скачивается в задаче и уходит агенту байтами — ссылка с токеном бота наружу не передаётся
+			enqueuePostJob(ctx, chatID, "Обработка изображения", tgbotapi.ChatUploadPhoto, "Ошибка генерации изображения", "Попробуй отправить изображение ещё раз.",
+				func(ctx context.Context) (*PostJSON, error) {
+					data, err := downloadTelegramFile(ctx, bot, fileID)
+					if err != nil {
+						return nil, err
+					}
+					req.ImageBase64 = base64.StdEncoding.EncodeToString(data)
+					req.ImageMIME = http.DetectContentType(data)
+					post, err := aiAgent.GenerateImage(ctx, chatID, req)
+					return withRequestedCanvas(post, err, req.Canvas)
+				}, bot)
+			return
+		}
+	}
+
+	// Приглашение в команду: deep-link /start <токен>
+	if message.IsCommand() && message.Command() == "start" && message.CommandArguments() != "" {
+		initBackendUser(ctx, message)
+		if !config.Features.Workspaces {
+			bot.Send(tgbotapi.NewMessage(chatID, featureDisabledText))
 			return
 		}
+		acceptWorkspaceInvite(message, bot)
+		return
+	}
+
+	// /cancel прерывает любой сценарий
+	if text == "/cancel" {
+		CancelFlow(state, bot)
+		return
+	}
+
+	// Кнопки главного меню и команды всегда прерывают незавершённый сценарий,
+	// а не считаются ответом на вопрос
+	if state.State != "idle" && (isMainMenuButton(text) || strings.HasPrefix(text, "/")) {
+		ResetUserState(chatID)
 	}
 
 	// Если в состоянии опроса/ввода — обрабатываем как ответ
@@ -75,7 +101,21 @@ func handleMessage(message *tgbotapi.Message, bot *tgbotapi.BotAPI) {
 			bot.Send(tgbotapi.NewMessage(chatID, "⚠️ Пожалуйста, введи текстовое описание или прикрепи изображение."))
 			return
 		}
-		processStateInput(state, text, bot)
+		processStateInput(ctx, state, text, bot)
+		return
+	}
+
+	// Кнопка профилей в главном меню содержит название активного профиля
+	if strings.HasPrefix(text, profileButtonPrefix) {
+		sendProfilesMenu(bot, chatID)
+		return
+	}
+
+	// Кнопка выключенной функции могла остаться в клавиатуре, отправленной до перезапуска
+	if !menuButtonEnabled(text) {
+		msg := tgbotapi.NewMessage(chatID, featureDisabledText)
+		msg.ReplyMarkup = MainMenu(ActiveProfileTitle(chatID))
+		bot.Send(msg)
 		return
 	}
 
@@ -83,26 +123,7 @@ func handleMessage(message *tgbotapi.Message, bot *tgbotapi.BotAPI) {
 	switch text {
 	case "/start":
 		// Инициализируем пользователя в бэкенде при первом взаимодействии
-		username := ""
-		if message.From != nil {
-			if message.From.UserName != "" {
-				username = message.From.UserName
-			} else {
-				// Если username нет, используем имя и фамилию
-				if message.From.FirstName != "" {
-					username = message.From.FirstName
-					if message.From.LastName != "" {
-						username += " " + message.From.LastName
-					}
-				} else {
-					username = fmt.Sprintf("user_%d", chatID)
-				}
-			}
-			// Отправляем инициализацию пользователя (игнорируем ошибки, т.к. пользователь может быть уже инициализирован)
-			if err := InitUser(chatID, username); err != nil {
-				log.Printf("[WARN] Failed to initialize user %d: %v", chatID, err)
-			}
-		}
+		initBackendUser(ctx, message)
 
 		welcomeText := `👋 Добро пожаловать в NKOshka Bot!
 
@@ -126,25 +147,46 @@ func handleMessage(message *tgbotapi.Message, bot *tgbotapi.BotAPI) {
 		bot.Send(msg)
 	case "/help", "Помощь":
 		sendHelpMessage(bot, chatID)
+	case "/jobs":
+		sendJobsMenu(bot, chatID, 0)
 	case "Генерация текста":
+		if !requireEditor(chatID, bot) {
+			return
+		}
 		msg := tgbotapi.NewMessage(chatID, "📝 Выбери режим генерации текста:\n\n• Свободный текст — опиши идею поста\n• Структурированная форма — пошаговый ввод данных о событии")
 		msg.ReplyMarkup = TextModesInline()
 		bot.Send(msg)
 	case "Генерация картинки":
+		if !requireEditor(chatID, bot) {
+			return
+		}
 		state.State = "image_desc"
+		delete(state.TempData, "canvas")
 		SaveUserState(state)
-		bot.Send(tgbotapi.NewMessage(chatID, "🎨 Опиши картинку, которую нужно создать, или прикрепи изображение для обработки:\n\n💡 Чем подробнее описание, тем лучше результат!"))
+		// Формат можно не выбирать: описание или фото сразу запускают генерацию в формате по умолчанию
+		msg := tgbotapi.NewMessage(chatID, "🖼 Выбери формат картинки:\n\nИли сразу опиши её либо прикрепи изображение — тогда будет формат по умолчанию.")
+		msg.ReplyMarkup = CanvasPresetsInline()
+		bot.Send(msg)
 	case "Редактор текста":
+		if !requireEditor(chatID, bot) {
+			return
+		}
 		state.State = "edit_text"
 		SaveUserState(state)
-		bot.Send(tgbotapi.NewMessage(chatID, "✏️ Введи текст, который нужно исправить и улучшить:\n\nЯ найду ошибки, улучшу стиль и сделаю текст более читаемым."))
-	case "Контент-план":
-		msg := tgbotapi.NewMessage(chatID, "📅 На сколько дней создать контент-план?\n\nВыбери готовый вариант или укажи свой период.")
-		msg.ReplyMarkup = ContentPlanPeriodInline()
+		msg := tgbotapi.NewMessage(chatID, "✏️ Введи текст, который нужно исправить и улучшить:\n\nЯ найду ошибки, улучшу стиль и сделаю текст более читаемым.")
+		msg.ReplyMarkup = CancelInline()
 		bot.Send(msg)
+	case "Контент-план":
+		if !requireEditor(chatID, bot) {
+			return
+		}
+		StartWizard("plan", state, bot)
 	case "Ввести данные НКО":
-		// Показываем текущие данные НКО
+		// Показываем текущие данные НКО (активного профиля)
 		nkoInfo := "📋 Текущие данные НКО:\n\n"
+		if title := ActiveProfileTitle(chatID); title != "" {
+			nkoInfo += "🏢 Профиль: " + title + "\n"
+		}
 		if state.NKO.Name != "" {
 			nkoInfo += "🏷️ Название: " + state.NKO.Name + "\n"
 		}
@@ -161,13 +203,18 @@ func handleMessage(message *tgbotapi.Message, bot *tgbotapi.BotAPI) {
 			nkoInfo += "⚠️ Данные НКО не заполнены.\n\n"
 			nkoInfo += "Для создания качественного контента рекомендуется заполнить информацию о НКО."
 		}
+		if !canEdit(ActiveRole(chatID)) {
+			// Наблюдатель может только посмотреть данные
+			bot.Send(tgbotapi.NewMessage(chatID, nkoInfo))
+			return
+		}
 		nkoInfo += "\n\n🔄 Обновить данные НКО?"
 		msg := tgbotapi.NewMessage(chatID, nkoInfo)
 		msg.ReplyMarkup = YesNoInline()
 		bot.Send(msg)
 	default:
 		msg := tgbotapi.NewMessage(chatID, "❓ Не понял команду. Выбери действие из меню ниже:")
-		msg.ReplyMarkup = MainMenu()
+		msg.ReplyMarkup = MainMenu(ActiveProfileTitle(chatID))
 		bot.Send(msg)
 	}
 }
@@ -242,160 +289,72 @@ func buildPrompt(mode string, ideaOrExample string, desc string, nko NKOData, te
 }
 
 // processStateInput — обработка ввода в состояниях
-func processStateInput(state *UserState, input string, bot *tgbotapi.BotAPI) {
+func processStateInput(ctx context.Context, state *UserState, input string, bot *tgbotapi.BotAPI) {
 	chatID := state.ChatID
 
+	// Многошаговые сценарии (данные НКО, структурированная форма, контент-план) — в wizard.go
+	if handleWizardInput(ctx, state, input, bot) {
+		return
+	}
+
 	switch state.State {
-	case "nko_name", "nko_update_name":
-		state.NKO.Name = input
-		if state.State == "nko_update_name" {
-			state.State = "nko_update_desc"
-		} else {
-			state.State = "nko_desc"
-		}
-		SaveUserState(state)
-		bot.Send(tgbotapi.NewMessage(chatID, "📝 Опиши свою НКО:\n\nРасскажи, чем занимается твоя организация, какие цели и задачи она решает."))
-	case "nko_desc", "nko_update_desc":
-		state.NKO.Description = input
-		if state.State == "nko_update_desc" {
-			state.State = "nko_update_activities"
-		} else {
-			state.State = "nko_activities"
+	case "profile_new_name":
+		profile, err := CreateProfile(chatID, input)
+		if err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось создать профиль: "+err.Error()))
+			return
 		}
-		SaveUserState(state)
-		bot.Send(tgbotapi.NewMessage(chatID, "🎯 Укажи формы деятельности НКО:\n\nНапример: помощь бездомным, экологические проекты, образовательные программы и т.д."))
-	case "nko_activities", "nko_update_activities":
-		state.NKO.Activities = input
-		state.State = "nko_style"
-		SaveUserState(state)
-		msg := tgbotapi.NewMessage(chatID, "✨ Выбери стиль постов для твоей НКО:\n\nСтиль влияет на тон и подачу контента. Выбери наиболее подходящий вариант.")
-		msg.ReplyMarkup = StylesInline()
+		ResetUserState(chatID)
+		msg := tgbotapi.NewMessage(chatID, "✅ Профиль «"+profile.Title+"» создан и выбран активным.\n\nЗаполнить данные НКО для этого профиля?")
+		msg.ReplyMarkup = YesNoInline()
 		bot.Send(msg)
+		sendMainMenuNote(bot, chatID)
+		return
 	case "image_desc":
-		data := map[string]interface{}{
-			"desc": input,
-			"nko":  state.NKO,
-		}
-		post, err := CallBackend("/generate_image", data, chatID)
-		if err != nil {
-			bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка генерации изображения: "+err.Error()+"\n\nПопробуй ещё раз или измени описание."))
-		} else {
-			SendPostToUser(chatID, post, bot)
-			msg := tgbotapi.NewMessage(chatID, "✨ Готово! Выбери действие с постом:")
-			msg.ReplyMarkup = PostActionInline(post.PostID)
-			bot.Send(msg)
-		}
+		canvas := presetCanvas(state.TempData["canvas"])
 		ResetUserState(chatID)
+		enqueueImageJob(ctx, chatID, agent.GenerateImageRequest{Desc: input, Canvas: canvas, NKO: agentNKO(state.NKO)}, bot)
 	case "edit_text":
-		data := map[string]interface{}{
-			"text": input,
-		}
-		post, err := CallBackend("/edit_text", data, chatID)
-		if err != nil {
-			bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка редактирования текста: "+err.Error()+"\n\nПопробуй ещё раз."))
-		} else {
-			// Для редактора: post.MainText — исправленный, ошибки можно в content
-			bot.Send(tgbotapi.NewMessage(chatID, "✅ Текст исправлен и улучшен:\n\n"+post.MainText))
-		}
 		ResetUserState(chatID)
+		EnqueueJob(ctx, &Job{
+			ChatID:    chatID,
+			Title:     "Редактирование текста",
+			Action:    tgbotapi.ChatTyping,
+			ErrorText: "Ошибка редактирования текста",
+			Hint:      "Попробуй ещё раз.",
+			Run: func(ctx context.Context, job *Job, bot *tgbotapi.BotAPI) error {
+				resp, err := aiAgent.EditText(ctx, chatID, agent.EditTextRequest{Text: input})
+				if err != nil {
+					return err
+				}
+				job.Reply(bot, "✅ Текст исправлен и улучшен:\n\n"+resp.MainText)
+				return nil
+			},
+		}, bot)
 	case "text_free_input":
 		// Формируем промпт на основе данных НКО и идеи
 		prompt := buildPrompt("free", input, "", state.NKO, nil)
-		data := map[string]interface{}{
-			"prompt": prompt,
-			"nko":    state.NKO,
-		}
-		post, err := CallBackend("/generate_text", data, chatID)
-		if err != nil {
-			bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка генерации текста: "+err.Error()+"\n\nПопробуй ещё раз или измени запрос."))
-		} else {
-			SendPostToUser(chatID, post, bot)
-			msg := tgbotapi.NewMessage(chatID, "✨ Готово! Выбери действие с постом:")
-			msg.ReplyMarkup = PostActionInline(post.PostID)
-			bot.Send(msg)
-		}
 		ResetUserState(chatID)
-	case "plan_period":
-		// Валидация ввода
-		daysNum, err := strconv.Atoi(input)
-		if err != nil || daysNum < 1 || daysNum > 365 {
-			bot.Send(tgbotapi.NewMessage(chatID, "❌ Введи число от 1 до 365"))
-			return
-		}
-		state.TempData["plan_days"] = input
-		state.State = "plan_frequency"
-		SaveUserState(state)
-		msg := tgbotapi.NewMessage(chatID, "📊 Как часто публиковать посты?\n\nВыбери подходящую частоту публикаций для контент-плана:")
-		msg.ReplyMarkup = ContentPlanFrequencyInline()
-		bot.Send(msg)
-		return
-
-	// Структурированная форма генерации текста
-	case "text_struct_event":
-		state.TempData["event"] = input
-		state.State = "text_struct_date"
-		SaveUserState(state)
-		bot.Send(tgbotapi.NewMessage(chatID, "📅 Укажи дату события:\n\nНапример: 25 декабря 2024 или 25.12.2024"))
-		return
-	case "text_struct_date":
-		state.TempData["date"] = input
-		state.State = "text_struct_location"
-		SaveUserState(state)
-		bot.Send(tgbotapi.NewMessage(chatID, "📍 Укажи место проведения события:\n\nНапример: Москва, концертный зал или онлайн"))
-		return
-	case "text_struct_location":
-		state.TempData["location"] = input
-		state.State = "text_struct_invited"
-		SaveUserState(state)
-		bot.Send(tgbotapi.NewMessage(chatID, "👥 Кто приглашён на событие?\n\nОпиши аудиторию или спикеров. Например: известные музыканты, волонтёры, эксперты и т.д."))
-		return
-	case "text_struct_invited":
-		state.TempData["invited"] = input
-		state.State = "text_struct_details"
-		SaveUserState(state)
-		bot.Send(tgbotapi.NewMessage(chatID, "📝 Дополнительные детали:\n\nУкажи программу мероприятия, условия участия, контакты и другую важную информацию."))
-		return
-	case "text_struct_details":
-		state.TempData["details"] = input
-		// Формируем промпт на основе всех собранных данных
-		prompt := buildPrompt("structured", "", "", state.NKO, state.TempData)
-		data := map[string]interface{}{
-			"prompt": prompt,
-			"nko":    state.NKO,
-		}
-		post, err := CallBackend("/generate_text", data, chatID)
-		if err != nil {
-			bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка генерации текста: "+err.Error()+"\n\nПопробуй ещё раз или измени запрос."))
-		} else {
-			SendPostToUser(chatID, post, bot)
-			msg := tgbotapi.NewMessage(chatID, "✨ Готово! Выбери действие с постом:")
-			msg.ReplyMarkup = PostActionInline(post.PostID)
-			bot.Send(msg)
-		}
-		ResetUserState(chatID)
-		return
+		enqueueTextJob(ctx, chatID, agent.GenerateTextRequest{Prompt: prompt, NKO: agentNKO(state.NKO)}, bot)
 
 	// Отправка поста в чат
 	case "post_send_chat":
-		postID := state.TempData["post_id"]
-		chatTarget := input
-		data := map[string]interface{}{
-			"post_id": postID,
-			"chat_id": chatTarget,
-		}
-		_, err := CallBackend("/send_post", data, chatID)
-		if err != nil {
-			bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка отправки поста: "+err.Error()+"\n\nПроверь правильность chat_id или username."))
-		} else {
-			bot.Send(tgbotapi.NewMessage(chatID, "✅ Пост успешно отправлен в чат: "+chatTarget))
-		}
+		sendPostToChat(ctx, chatID, state.TempData["post_id"], input, bot)
 		ResetUserState(chatID)
 		return
+
+	default:
+		// Состояние из старой версии (nko_update_*, plan_period, …) или неизвестное — иначе ввод пропадал бы молча
+		requestLogger(ctx).Warn("Unknown state, resetting", "chat_id", chatID, "state", state.State)
+		ResetUserState(chatID)
+		msg := tgbotapi.NewMessage(chatID, "⚠️ Предыдущий сценарий устарел и был сброшен. Выбери действие в меню:")
+		msg.ReplyMarkup = MainMenu(ActiveProfileTitle(chatID))
+		bot.Send(msg)
 	}
 }
 
 // handleCallback — обработка inline-кнопок
-func handleCallback(callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+func handleCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
 	chatID := callback.Message.Chat.ID
 	data := callback.Data
 
@@ -403,23 +362,76 @@ func handleCallback(callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
 
 	state := GetUserState(chatID)
 
+	// «✖️ Отмена» есть на каждом шаге любого сценария
+	if data == cancelCallback {
+		CancelFlow(state, bot)
+		return
+	}
+
+	// Кнопки текущего шага мастера (стиль НКО, период и частота контент-плана)
+	if handleWizardCallback(ctx, state, data, bot) {
+		return
+	}
+
+	// Обработка callback'ов для команды (рабочего пространства)
+	if strings.HasPrefix(data, "ws_") {
+		if !config.Features.Workspaces {
+			bot.Send(tgbotapi.NewMessage(chatID, featureDisabledText))
+			return
+		}
+		handleWorkspaceCallback(callback, state, data, bot)
+		return
+	}
+
+	// Отмена фоновой задачи: под сообщением «⏳ …» (job_cancel_) или в списке /jobs (jobs_cancel_)
+	if strings.HasPrefix(data, "job_cancel_") {
+		if !CancelJob(chatID, strings.TrimPrefix(data, "job_cancel_"), bot) {
+			bot.Send(tgbotapi.NewMessage(chatID, "Эта задача уже завершилась."))
+		}
+		return
+	}
+	if strings.HasPrefix(data, "jobs_cancel_") {
+		CancelJob(chatID, strings.TrimPrefix(data, "jobs_cancel_"), bot)
+		sendJobsMenu(bot, chatID, callback.Message.MessageID)
+		return
+	}
+
+	// Выбор формата картинки перед её описанием
+	if strings.HasPrefix(data, canvasCallbackPrefix) {
+		preset, ok := findCanvasPreset(strings.TrimPrefix(data, canvasCallbackPrefix))
+		switch {
+		case !config.Features.ImageGeneration:
+			bot.Send(tgbotapi.NewMessage(chatID, featureDisabledText))
+		case !ok || state.State != "image_desc":
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ Выбор формата устарел. Нажми «Генерация картинки» ещё раз."))
+		default:
+			state.TempData["canvas"] = preset.Name
+			SaveUserState(state)
+			msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Формат: %s (%d×%d)\n\n", preset.Title, preset.Width, preset.Height)+imageDescPrompt)
+			msg.ReplyMarkup = CancelInline()
+			bot.Send(msg)
+		}
+		return
+	}
+
+	// Обработка callback'ов для профилей НКО
+	if strings.HasPrefix(data, "prof_") {
+		handleProfileCallback(state, data, bot)
+		return
+	}
+
 	// Обработка callback'ов для НКО
 	switch data {
 	case "nko_yes":
-		// Проверяем, это обновление или первичная настройка
-		if state.NKO.Name != "" {
-			// Обновление существующих данных
-			state.State = "nko_update_name"
-		} else {
-			// Первичная настройка
-			state.State = "nko_name"
+		if !requireEditor(chatID, bot) {
+			return
 		}
-		SaveUserState(state)
-		bot.Send(tgbotapi.NewMessage(chatID, "🏷️ Введи название твоей НКО:"))
+		// При обновлении мастер покажет текущие значения, их можно оставить
+		StartWizard("nko", state, bot)
 		return
 	case "nko_skip":
 		msg := tgbotapi.NewMessage(chatID, "✅ Хорошо, будем создавать обезличенные посты.\n\nВыбери функцию из меню:")
-		msg.ReplyMarkup = MainMenu()
+		msg.ReplyMarkup = MainMenu(ActiveProfileTitle(chatID))
 		bot.Send(msg)
 		state.State = "idle"
 		SaveUserState(state)
@@ -429,186 +441,442 @@ func handleCallback(callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
 	// Обработка callback'ов для режимов генерации текста
 	switch data {
 	case "text_free":
+		if !requireEditor(chatID, bot) {
+			return
+		}
 		state.State = "text_free_input"
 		SaveUserState(state)
-		bot.Send(tgbotapi.NewMessage(chatID, "💡 Опиши идею для поста:\n\nРасскажи, о чём должен быть пост, какую информацию нужно донести до аудитории."))
+		msg := tgbotapi.NewMessage(chatID, "💡 Опиши идею для поста:\n\nРасскажи, о чём должен быть пост, какую информацию нужно донести до аудитории.")
+		msg.ReplyMarkup = CancelInline()
+		bot.Send(msg)
 		return
 	case "text_struct":
-		state.State = "text_struct_event"
-		SaveUserState(state)
-		bot.Send(tgbotapi.NewMessage(chatID, "📌 Опиши событие (что за мероприятие, повод и т.д.):"))
+		if !requireEditor(chatID, bot) {
+			return
+		}
+		StartWizard("text_struct", state, bot)
 		return
 	}
 
-	// Обработка callback'ов для стилей
-	switch data {
-	case "style_conversational":
-		state.NKO.Style = "разговорный"
-		state.State = "idle"
-		SaveUserState(state)
-		msg := tgbotapi.NewMessage(chatID, "✅ Стиль постов сохранён: Разговорный\n\nТеперь все посты будут создаваться в разговорном, живом стиле. Выбери функцию из меню:")
-		msg.ReplyMarkup = MainMenu()
-		bot.Send(msg)
-		return
-	case "style_formal":
-		state.NKO.Style = "официальный"
-		state.State = "idle"
-		SaveUserState(state)
-		msg := tgbotapi.NewMessage(chatID, "✅ Стиль постов сохранён: Официальный\n\nТеперь все посты будут создаваться в официальном, деловом стиле. Выбери функцию из меню:")
-		msg.ReplyMarkup = MainMenu()
-		bot.Send(msg)
-		return
-	case "style_artistic":
-		state.NKO.Style = "художественный"
-		state.State = "idle"
+	// Обработка действий с постами (отправить, перегенерировать)
+	switch {
+	case strings.HasPrefix(data, "post_send_"):
+		if !requireEditor(chatID, bot) {
+			return
+		}
+		state.State = "post_send_chat"
+		state.TempData["post_id"] = strings.TrimPrefix(data, "post_send_")
 		SaveUserState(state)
-		msg := tgbotapi.NewMessage(chatID, "✅ Стиль постов сохранён: Художественный\n\nТеперь все посты будут создаваться в художественном, образном стиле. Выбери функцию из меню:")
-		msg.ReplyMarkup = MainMenu()
+		msg := tgbotapi.NewMessage(chatID, "📤 В какой чат отправить пост?\n\nВведи chat_id (например: -1001234567890) или username канала/группы (например: @channel_name):")
+		msg.ReplyMarkup = CancelInline()
+		if workspace, ok := ActiveWorkspace(chatID); ok && config.Features.Workspaces && len(workspace.Channels) > 0 {
+			msg.Text += "\n\nИли выбери один из каналов команды:"
+			msg.ReplyMarkup = ChannelsInline(workspace.Channels)
+		}
 		bot.Send(msg)
 		return
-	case "style_emotional":
-		state.NKO.Style = "эмоциональный"
-		state.State = "idle"
-		SaveUserState(state)
-		msg := tgbotapi.NewMessage(chatID, "✅ Стиль постов сохранён: Эмоциональный\n\nТеперь все посты будут создаваться в эмоциональном, вдохновляющем стиле. Выбери функцию из меню:")
-		msg.ReplyMarkup = MainMenu()
-		bot.Send(msg)
+	case strings.HasPrefix(data, "post_to_"):
+		// Выбор канала команды из списка (индекс в списке каналов профиля)
+		if !requireEditor(chatID, bot) {
+			return
+		}
+		postID := state.TempData["post_id"]
+		workspace, _ := ActiveWorkspace(chatID)
+		idx, err := strconv.Atoi(strings.TrimPrefix(data, "post_to_"))
+		if state.State != "post_send_chat" || postID == "" || err != nil || idx < 0 || idx >= len(workspace.Channels) {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не найден. Нажми «📤 Отправить» под постом ещё раз."))
+			return
+		}
+		sendPostToChat(ctx, chatID, postID, workspace.Channels[idx], bot)
+		ResetUserState(chatID)
 		return
-	case "style_informational":
-		state.NKO.Style = "информационный"
-		state.State = "idle"
-		SaveUserState(state)
-		msg := tgbotapi.NewMessage(chatID, "✅ Стиль постов сохранён: Информационный\n\nТеперь все посты будут создаваться в информационном, новостном стиле. Выбери функцию из меню:")
-		msg.ReplyMarkup = MainMenu()
-		bot.Send(msg)
+	case strings.HasPrefix(data, "post_regenerate_"):
+		if !requireEditor(chatID, bot) {
+			return
+		}
+		req := agent.RegeneratePostRequest{PostID: strings.TrimPrefix(data, "post_regenerate_"), Regenerate: true}
+		enqueuePostJob(ctx, chatID, "Перегенерация поста", tgbotapi.ChatTyping, "Ошибка перегенерации поста", "Попробуй ещё раз.",
+			func(ctx context.Context) (*PostJSON, error) { return aiAgent.RegeneratePost(ctx, chatID, req) }, bot)
 		return
-	case "style_call_to_action":
-		state.NKO.Style = "призыв к действию"
-		state.State = "idle"
-		SaveUserState(state)
-		msg := tgbotapi.NewMessage(chatID, "✅ Стиль постов сохранён: Призыв к действию\n\nТеперь все посты будут создаваться в стиле призыва к действию, мотивирующем и побуждающем. Выбери функцию из меню:")
-		msg.ReplyMarkup = MainMenu()
-		bot.Send(msg)
+	}
+
+	// Если callback не распознан
+	bot.Send(tgbotapi.NewMessage(chatID, "❓ Неизвестная команда. Выбери действие из меню:"))
+}
+
+// enqueuePostJob — генерация поста в фоне; готовый пост показывается через deliverPost
+func enqueuePostJob(ctx context.Context, chatID int64, title, action, errorText, hint string, generate func(ctx context.Context) (*PostJSON, error), bot *tgbotapi.BotAPI) bool {
+	return EnqueueJob(ctx, &Job{
+		ChatID:    chatID,
+		Title:     title,
+		Action:    action,
+		ErrorText: errorText,
+		Hint:      hint,
+		Run: func(ctx context.Context, job *Job, bot *tgbotapi.BotAPI) error {
+			post, err := generate(ctx)
+			if err != nil {
+				return err
+			}
+			deliverPost(ctx, job, *post, bot)
+			return nil
+		},
+	}, bot)
+}
+
+// enqueueTextJob — генерация текста поста в фоне
+func enqueueTextJob(ctx context.Context, chatID int64, req agent.GenerateTextRequest, bot *tgbotapi.BotAPI) bool {
+	return enqueuePostJob(ctx, chatID, "Генерация текста", tgbotapi.ChatTyping, "Ошибка генерации текста", "Попробуй ещё раз или измени запрос.",
+		func(ctx context.Context) (*PostJSON, error) { return aiAgent.GenerateText(ctx, chatID, req) }, bot)
+}
+
+// enqueueImageJob — генерация картинки в фоне
+func enqueueImageJob(ctx context.Context, chatID int64, req agent.GenerateImageRequest, bot *tgbotapi.BotAPI) bool {
+	return enqueuePostJob(ctx, chatID, "Генерация картинки", tgbotapi.ChatUploadPhoto, "Ошибка генерации изображения", "Попробуй ещё раз или измени описание.",
+		func(ctx context.Context) (*PostJSON, error) {
+			post, err := aiAgent.GenerateImage(ctx, chatID, req)
+			return withRequestedCanvas(post, err, req.Canvas)
+		}, bot)
+}
+
+// deliverPost — показать готовый пост с кнопками действий и добавить его в ленту команды
+// Текстом поста заменяется сообщение «⏳ …» задачи, картинка и кнопки приходят следом.
+func deliverPost(ctx context.Context, job *Job, post PostJSON, bot *tgbotapi.BotAPI) {
+	chatID := job.ChatID
+	text := post.MainText
+	if text == "" {
+		text = "✅ " + job.Title + " — готово."
+	}
+	job.Reply(bot, text)
+	if err := SendPostImage(ctx, chatID, post, bot); err != nil {
+		job.logger().Error("Failed to send post image", "post_id", post.PostID, "err", err)
+	}
+	msg := tgbotapi.NewMessage(chatID, "✨ Готово! Выбери действие с постом:")
+	msg.ReplyMarkup = PostActionInline(post.PostID)
+	bot.Send(msg)
+
+	shared := SharedPost{PostID: post.PostID, Author: chatID, MainText: post.MainText, CreatedAt: time.Now()}
+	if err := RecordPost(chatID, shared); err != nil {
+		job.logger().Error("Failed to record post", "post_id", post.PostID, "err", err)
+	}
+}
+
+// sendPostToChat — опубликовать пост в чат через AI агента и запомнить канал для команды
+func sendPostToChat(ctx context.Context, chatID int64, postID string, chatTarget string, bot *tgbotapi.BotAPI) {
+	// Роль могли понизить, пока пользователь вводил чат
+	if !requireEditor(chatID, bot) {
 		return
-	case "style_gratitude":
-		state.NKO.Style = "благодарственный"
-		state.State = "idle"
-		SaveUserState(state)
-		msg := tgbotapi.NewMessage(chatID, "✅ Стиль постов сохранён: Благодарственный\n\nТеперь все посты будут создаваться в благодарственном, тёплом стиле. Выбери функцию из меню:")
-		msg.ReplyMarkup = MainMenu()
-		bot.Send(msg)
+	}
+	req := agent.SendPostRequest{PostID: postID, ChatID: chatTarget}
+	EnqueueJob(ctx, &Job{
+		ChatID:    chatID,
+		Title:     "Отправка поста",
+		Action:    tgbotapi.ChatTyping,
+		ErrorText: "Ошибка отправки поста",
+		Hint:      "Проверь правильность chat_id или username.",
+		Run: func(ctx context.Context, job *Job, bot *tgbotapi.BotAPI) error {
+			if _, err := aiAgent.SendPost(ctx, chatID, req); err != nil {
+				return err
+			}
+			job.Reply(bot, "✅ Пост успешно отправлен в чат: "+chatTarget)
+			if err := RecordChannel(chatID, chatTarget); err != nil {
+				job.logger().Error("Failed to record channel", "chat", chatTarget, "err", err)
+			}
+			return nil
+		},
+	}, bot)
+}
+
+// processContentPlan — обработка создания контент-плана
+func processContentPlan(ctx context.Context, chatID int64, days string, frequency string, state *UserState, bot *tgbotapi.BotAPI) {
+	req := agent.ContentPlanRequest{Days: days, Freq: frequency, NKO: agentNKO(state.NKO)}
+	ResetUserState(chatID)
+	EnqueueJob(ctx, &Job{
+		ChatID:    chatID,
+		Title:     "Контент-план на " + days + " дней",
+		Action:    tgbotapi.ChatTyping,
+		ErrorText: "Ошибка создания контент-плана",
+		Hint:      "Попробуй ещё раз.",
+		Run: func(ctx context.Context, job *Job, bot *tgbotapi.BotAPI) error {
+			plan, err := aiAgent.ContentPlan(ctx, chatID, req)
+			if err != nil {
+				return err
+			}
+			job.Reply(bot, "📅 Контент-план на "+days+" дней (частота публикаций: "+frequency+"):\n\n"+plan.MainText)
+			return nil
+		},
+	}, bot)
+}
+
+// telegramUserName — имя пользователя для бэкенда и списка участников команды
+func telegramUserName(from *tgbotapi.User, chatID int64) string {
+	if from == nil {
+		return fmt.Sprintf("user_%d", chatID)
+	}
+	if from.UserName != "" {
+		return from.UserName
+	}
+	// Если username нет, используем имя и фамилию
+	if from.FirstName != "" {
+		name := from.FirstName
+		if from.LastName != "" {
+			name += " " + from.LastName
+		}
+		return name
+	}
+	return fmt.Sprintf("user_%d", chatID)
+}
+
+// initBackendUser — инициализация пользователя в бэкенде (ошибки только логируем,
+// т.к. пользователь может быть уже инициализирован)
+func initBackendUser(ctx context.Context, message *tgbotapi.Message) {
+	if message.From == nil {
 		return
-	case "style_friendly":
-		state.NKO.Style = "дружелюбный"
-		state.State = "idle"
-		SaveUserState(state)
-		msg := tgbotapi.NewMessage(chatID, "✅ Стиль постов сохранён: Дружелюбный\n\nТеперь все посты будут создаваться в дружелюбном, неформальном стиле. Выбери функцию из меню:")
-		msg.ReplyMarkup = MainMenu()
+	}
+	chatID := message.Chat.ID
+	req := agent.InitUserRequest{TgID: chatID, Username: telegramUserName(message.From, chatID)}
+	// В фоне: ответ не нужен для приветствия, а агент может отвечать долго
+	logger := requestLogger(ctx).With("chat_id", chatID)
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		resp, err := aiAgent.InitUser(ctx, req)
+		if err != nil {
+			logger.Warn("Failed to initialize user", "err", err)
+			return
+		}
+		logger.Debug("User initialized successfully", "status", resp.Status)
+	}()
+}
+
+// requireEditor — проверяет, что пользователь может менять данные и публиковать в активном профиле
+func requireEditor(chatID int64, bot *tgbotapi.BotAPI) bool {
+	role := ActiveRole(chatID)
+	if canEdit(role) {
+		return true
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, "🔒 Недостаточно прав: в профиле «"+ActiveProfileTitle(chatID)+"» у тебя роль «"+roleTitle(role)+"».\n\nГенерировать, публиковать посты и менять данные НКО могут только редакторы."))
+	return false
+}
+
+// acceptWorkspaceInvite — вступление в команду по ссылке-приглашению
+func acceptWorkspaceInvite(message *tgbotapi.Message, bot *tgbotapi.BotAPI) {
+	chatID := message.Chat.ID
+	ResetUserState(chatID)
+
+	profile, role, err := AcceptInvite(chatID, message.CommandArguments(), telegramUserName(message.From, chatID))
+	if err != nil {
+		msg := tgbotapi.NewMessage(chatID, "❌ Не удалось вступить в команду: "+err.Error()+"\n\nПопроси владельца профиля прислать новую ссылку.")
+		msg.ReplyMarkup = MainMenu(ActiveProfileTitle(chatID))
 		bot.Send(msg)
 		return
 	}
 
-	// Обработка callback'ов для контент-плана (выбор периода)
-	switch data {
-	case "plan_7":
-		state.TempData["plan_days"] = "7"
-		state.State = "plan_frequency"
-		SaveUserState(state)
-		msg := tgbotapi.NewMessage(chatID, "📊 Как часто публиковать посты?\n\nВыбери подходящую частоту публикаций для контент-плана:")
-		msg.ReplyMarkup = ContentPlanFrequencyInline()
-		bot.Send(msg)
+	msg := tgbotapi.NewMessage(chatID, "🎉 Ты в команде «"+profile.Title+"» с ролью «"+roleTitle(role)+"».\n\nПрофиль выбран активным: общие данные НКО, посты и каналы команды уже доступны.")
+	msg.ReplyMarkup = MainMenu(profile.Title)
+	bot.Send(msg)
+
+	// Сообщаем владельцу о новом участнике
+	if profile.Owner != chatID {
+		bot.Send(tgbotapi.NewMessage(profile.Owner, "👥 "+telegramUserName(message.From, chatID)+" присоединился к команде «"+profile.Title+"» ("+roleTitle(role)+")."))
+	}
+}
+
+// sendTeamMenu — участники активного профиля и действия с командой
+func sendTeamMenu(bot *tgbotapi.BotAPI, chatID int64) {
+	workspace, ok := ActiveWorkspace(chatID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "🏢 Сначала создай или выбери профиль НКО."))
 		return
-	case "plan_14":
-		state.TempData["plan_days"] = "14"
-		state.State = "plan_frequency"
-		SaveUserState(state)
-		msg := tgbotapi.NewMessage(chatID, "📊 Как часто публиковать посты?\n\nВыбери подходящую частоту публикаций для контент-плана:")
-		msg.ReplyMarkup = ContentPlanFrequencyInline()
+	}
+
+	text := "👥 Команда профиля «" + workspace.Title + "»\n\n"
+	for memberID, member := range workspace.Members {
+		name := member.Name
+		if name == "" {
+			name = fmt.Sprintf("id %d", memberID)
+		}
+		if memberID == chatID {
+			name += " (ты)"
+		}
+		text += "• " + name + " — " + roleTitle(member.Role) + "\n"
+	}
+	if workspace.Owner == chatID {
+		text += "\nПригласи коллег: они получат доступ к данным НКО, общим постам и каналам."
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = TeamInline(workspace, chatID)
+	bot.Send(msg)
+}
+
+// handleWorkspaceCallback — приглашения, участники и общая лента постов команды
+func handleWorkspaceCallback(callback *tgbotapi.CallbackQuery, state *UserState, data string, bot *tgbotapi.BotAPI) {
+	chatID := state.ChatID
+
+	switch {
+	case data == "ws_team":
+		sendTeamMenu(bot, chatID)
+	case data == "ws_inv_editor", data == "ws_inv_viewer":
+		role := strings.TrimPrefix(data, "ws_inv_")
+		token, err := CreateInvite(chatID, role, telegramUserName(callback.From, chatID))
+		if err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось создать приглашение: "+err.Error()))
+			return
+		}
+		link := "https://t.me/" + bot.Self.UserName + "?start=" + token
+		bot.Send(tgbotapi.NewMessage(chatID, "🔗 Ссылка-приглашение (роль «"+roleTitle(role)+"»):\n\n"+link+"\n\nСсылка одноразовая и действует 7 дней."))
+	case strings.HasPrefix(data, "ws_kick_"):
+		memberID, err := strconv.ParseInt(strings.TrimPrefix(data, "ws_kick_"), 10, 64)
+		if err == nil {
+			err = RemoveMember(chatID, memberID)
+		}
+		if err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось исключить участника: "+err.Error()))
+			return
+		}
+		bot.Send(tgbotapi.NewMessage(chatID, "✅ Участник исключён из команды."))
+		sendTeamMenu(bot, chatID)
+	case data == "ws_posts":
+		workspace, ok := ActiveWorkspace(chatID)
+		if !ok || len(workspace.Posts) == 0 {
+			bot.Send(tgbotapi.NewMessage(chatID, "📰 В ленте команды пока нет постов."))
+			return
+		}
+		msg := tgbotapi.NewMessage(chatID, "📰 Последние посты команды «"+workspace.Title+"»:")
+		msg.ReplyMarkup = TeamPostsInline(workspace.Posts)
 		bot.Send(msg)
-		return
-	case "plan_30":
-		state.TempData["plan_days"] = "30"
-		state.State = "plan_frequency"
-		SaveUserState(state)
-		msg := tgbotapi.NewMessage(chatID, "📊 Как часто публиковать посты?\n\nВыбери подходящую частоту публикаций для контент-плана:")
-		msg.ReplyMarkup = ContentPlanFrequencyInline()
+	case strings.HasPrefix(data, "ws_post_"):
+		post, ok := FindSharedPost(chatID, strings.TrimPrefix(data, "ws_post_"))
+		if !ok {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ Пост не найден в ленте команды."))
+			return
+		}
+		text := post.MainText
+		if text == "" {
+			text = "(пост без текста)"
+		}
+		msg := tgbotapi.NewMessage(chatID, text)
+		if canEdit(ActiveRole(chatID)) {
+			msg.ReplyMarkup = PostActionInline(post.PostID)
+		}
 		bot.Send(msg)
-		return
-	case "plan_custom":
-		state.State = "plan_period"
-		SaveUserState(state)
-		bot.Send(tgbotapi.NewMessage(chatID, "📅 На сколько дней создать контент-план?\n\nВведи число от 1 до 365:"))
-		return
+	default:
+		bot.Send(tgbotapi.NewMessage(chatID, "❓ Неизвестная команда. Выбери действие из меню:"))
 	}
+}
 
-	// Обработка callback'ов для выбора частоты публикаций
-	switch data {
-	case "freq_daily":
-		days := state.TempData["plan_days"]
-		processContentPlan(chatID, days, "ежедневно", state, bot)
-		return
-	case "freq_every_other":
-		days := state.TempData["plan_days"]
-		processContentPlan(chatID, days, "через день", state, bot)
-		return
-	case "freq_twice_week":
-		days := state.TempData["plan_days"]
-		processContentPlan(chatID, days, "2 раза в неделю", state, bot)
-		return
-	case "freq_thrice_week":
-		days := state.TempData["plan_days"]
-		processContentPlan(chatID, days, "3 раза в неделю", state, bot)
-		return
+// sendProfilesMenu — список профилей НКО пользователя с кнопками управления
+func sendProfilesMenu(bot *tgbotapi.BotAPI, chatID int64) {
+	profiles, active := ListProfiles(chatID)
+	text := "🏢 Профили НКО\n\nНажми на профиль, чтобы сделать его активным. Активный профиль используется при генерации постов, картинок и контент-планов."
+	if len(profiles) == 0 {
+		text = "🏢 Профили НКО\n\nУ тебя пока нет профилей. Создай профиль для каждой организации, которую ведёшь."
 	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = ProfilesInline(profiles, active)
+	bot.Send(msg)
+}
 
-	// Обработка действий с постами (отправить, перегенерировать)
-	if len(data) > 10 {
-		prefix := data[:10]
-		if prefix == "post_send_" {
-			postID := data[10:]
-			state.State = "post_send_chat"
-			state.TempData["post_id"] = postID
-			SaveUserState(state)
-			bot.Send(tgbotapi.NewMessage(chatID, "📤 В какой чат отправить пост?\n\nВведи chat_id (например: -1001234567890) или username канала/группы (например: @channel_name):"))
+// sendMainMenuNote — обновляет главное меню (в нём отображается активный профиль)
+func sendMainMenuNote(bot *tgbotapi.BotAPI, chatID int64) {
+	title := ActiveProfileTitle(chatID)
+	text := "🏢 Активный профиль: " + title
+	if title == "" {
+		text = "🏢 Активного профиля нет"
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = MainMenu(title)
+	bot.Send(msg)
+}
+
+// handleProfileCallback — создание, переключение и удаление профилей НКО
+func handleProfileCallback(state *UserState, data string, bot *tgbotapi.BotAPI) {
+	chatID := state.ChatID
+
+	switch {
+	case data == "prof_menu":
+		sendProfilesMenu(bot, chatID)
+	case data == "prof_new":
+		state.State = "profile_new_name"
+		SaveUserState(state)
+		msg := tgbotapi.NewMessage(chatID, "🏷️ Введи название нового профиля:\n\nНапример, название организации, для которой ты ведёшь соцсети.")
+		msg.ReplyMarkup = CancelInline()
+		bot.Send(msg)
+	case data == "prof_del":
+		profiles, _ := ListProfiles(chatID)
+		msg := tgbotapi.NewMessage(chatID, "🗑 Какой профиль удалить?")
+		msg.ReplyMarkup = ProfilesDeleteInline(profiles)
+		bot.Send(msg)
+	case strings.HasPrefix(data, "prof_sw_"):
+		profile, err := SwitchProfile(chatID, strings.TrimPrefix(data, "prof_sw_"))
+		if err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось переключить профиль: "+err.Error()))
 			return
 		}
-		if len(data) > 15 && data[:15] == "post_regenerate_" {
-			postID := data[15:]
-			reqData := map[string]interface{}{
-				"post_id":    postID,
-				"regenerate": true,
-			}
-			post, err := CallBackend("/regenerate_post", reqData, chatID)
-			if err != nil {
-				bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка перегенерации поста: "+err.Error()+"\n\nПопробуй ещё раз."))
-			} else {
-				SendPostToUser(chatID, post, bot)
-				msg := tgbotapi.NewMessage(chatID, "✨ Готово! Выбери действие с постом:")
-				msg.ReplyMarkup = PostActionInline(post.PostID)
+		syncProfile(state)
+		msg := tgbotapi.NewMessage(chatID, "✅ Активный профиль: "+profile.Title)
+		msg.ReplyMarkup = MainMenu(profile.Title)
+		bot.Send(msg)
+	case strings.HasPrefix(data, "prof_delok_"):
+		if err := DeleteProfile(chatID, strings.TrimPrefix(data, "prof_delok_")); err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось удалить профиль: "+err.Error()))
+			return
+		}
+		syncProfile(state)
+		bot.Send(tgbotapi.NewMessage(chatID, "🗑 Профиль удалён."))
+		sendMainMenuNote(bot, chatID)
+	case strings.HasPrefix(data, "prof_del_"):
+		profileID := strings.TrimPrefix(data, "prof_del_")
+		profiles, _ := ListProfiles(chatID)
+		for _, profile := range profiles {
+			if profile.ID == profileID {
+				text := "⚠️ Удалить профиль «" + profile.Title + "» вместе с данными НКО? Это действие нельзя отменить."
+				if profile.Owner != chatID {
+					text = "⚠️ Выйти из команды «" + profile.Title + "»? Вернуться можно будет только по новому приглашению."
+				} else if len(profile.Members) > 1 {
+					text = "⚠️ Удалить профиль «" + profile.Title + "» для всей команды? Данные НКО, посты и каналы будут удалены у всех участников."
+				}
+				msg := tgbotapi.NewMessage(chatID, text)
+				msg.ReplyMarkup = ProfileDeleteConfirmInline(profileID)
 				bot.Send(msg)
+				return
 			}
-			return
 		}
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ Профиль не найден."))
+	default:
+		bot.Send(tgbotapi.NewMessage(chatID, "❓ Неизвестная команда. Выбери действие из меню:"))
 	}
-
-	// Если callback не распознан
-	bot.Send(tgbotapi.NewMessage(chatID, "❓ Неизвестная команда. Выбери действие из меню:"))
 }
 
-// processContentPlan — обработка создания контент-плана
-func processContentPlan(chatID int64, days string, frequency string, state *UserState, bot *tgbotapi.BotAPI) {
-	data := map[string]interface{}{
-		"days": days,
-		"freq": frequency,
-		"nko":  state.NKO,
+// sendJobsMenu — список задач пользователя с кнопками отмены
+// messageID != 0 — обновить уже показанный список.
+func sendJobsMenu(bot *tgbotapi.BotAPI, chatID int64, messageID int) {
+	list := UserJobs(chatID)
+	text := "✅ Задач в работе нет."
+	if len(list) > 0 {
+		text = "⏳ Задачи в работе:\n"
+		for i, job := range list {
+			status := "в очереди " + time.Since(job.CreatedAt).Round(time.Second).String()
+			if !job.StartedAt.IsZero() {
+				status = "выполняется " + time.Since(job.StartedAt).Round(time.Second).String()
+			}
+			text += fmt.Sprintf("\n%d. %s — %s", i+1, job.Title, status)
+		}
 	}
-	post, err := CallBackend("/content_plan", data, chatID)
-	if err != nil {
-		bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка создания контент-плана: "+err.Error()+"\n\nПопробуй ещё раз."))
-	} else {
-		bot.Send(tgbotapi.NewMessage(chatID, "📅 Контент-план на "+days+" дней (частота публикаций: "+frequency+"):\n\n"+post.MainText))
+
+	if messageID != 0 {
+		edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+		if len(list) > 0 {
+			markup := JobsInline(list)
+			edit.ReplyMarkup = &markup
+		}
+		bot.Send(edit)
+		return
 	}
-	ResetUserState(chatID)
+	msg := tgbotapi.NewMessage(chatID, text)
+	if len(list) > 0 {
+		msg.ReplyMarkup = JobsInline(list)
+	}
+	bot.Send(msg)
 }
 
 func sendHelpMessage(bot *tgbotapi.BotAPI, chatID int64) {
@@ -627,13 +895,19 @@ func sendHelpMessage(bot *tgbotapi.BotAPI, chatID int64) {
 • Редактор — исправляю ошибки
 • Контент-план — на неделю/месяц
 
+Задачи:
+/jobs — что сейчас генерируется; долгую задачу можно отменить
+
+Передумал?
+/cancel или кнопка «✖️ Отмена» — прервать текущее действие, «◀️ Назад» — вернуться на шаг назад
+
 Совет:
 Чем больше расскажешь о НКО — тем точнее посты!
 
 Готов? Нажми кнопку ниже или напиши /start`
 
 	msg := tgbotapi.NewMessage(chatID, helpText)
-	msg.ReplyMarkup = MainMenu()
+	msg.ReplyMarkup = MainMenu(ActiveProfileTitle(chatID))
 
 	bot.Send(msg)
 }
@@ -0,0 +1,195 @@
+// imagelayer.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/fogleman/gg"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	xdraw "golang.org/x/image/draw"
+)
+
+// maxImagePixels — предел размера исходной картинки слоя (защита от «бомб» из маленьких файлов)
+const maxImagePixels = 50_000_000
+
+// drawImage — накладывает изображение
+// Сначала из картинки вырезается crop (если задан).
+// С размерами width/height (или w/h) картинка вписывается в рамку x, y по fit:
+// cover (по умолчанию) — заполнить рамку, обрезав лишнее вокруг focus_x/focus_y;
+// contain — вписать целиком; fill — растянуть; none — без масштабирования, обрезав по рамке.
+// Без размеров картинка рисуется от x, y в масштабе scale, как раньше.
+// Дальше — flip_x/flip_y, маска (radius или mask: circle) и поворот rotation (в градусах) вокруг центра.
+func drawImage(ctx context.Context, bot *tgbotapi.BotAPI, dc *gg.Context, data map[string]interface{}) error {
+	img, err := layerImage(ctx, bot, data)
+	if err != nil {
+		return err
+	}
+
+	tile, x, y, err := fitImage(img, data)
+	if err != nil {
+		return err
+	}
+	flipImage(tile, getBool(data, "flip_x", false), getBool(data, "flip_y", false))
+	masked := maskImage(tile, data)
+
+	w, h := float64(masked.Bounds().Dx()), float64(masked.Bounds().Dy())
+	dc.Push()
+	if rotation := getFloat(data, "rotation", 0); rotation != 0 {
+		dc.RotateAbout(gg.Radians(rotation), x+w/2, y+h/2)
+	}
+	dc.DrawImage(masked, int(math.Round(x)), int(math.Round(y)))
+	dc.Pop()
+	return nil
+}
+
+// layerImage — исходная картинка слоя (image_base64, image_url или file_id, см. imagesource.go)
+func layerImage(ctx context.Context, bot *tgbotapi.BotAPI, data map[string]interface{}) (image.Image, error) {
+	imageBytes, err := layerImageBytes(ctx, bot, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Размер проверяем до декодирования: маленький файл может оказаться огромной картинкой
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(imageBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("image is too large (%dx%d)", cfg.Width, cfg.Height)
+	}
+
+	// Декодируем изображение
+	img, _, err := image.Decode(bytes.NewReader(imageBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// fitImage — масштабирует и обрезает картинку под рамку слоя
+// Возвращает готовый фрагмент и его левый верхний угол на холсте.
+func fitImage(img image.Image, data map[string]interface{}) (tile *image.RGBA, x, y float64, err error) {
+	src := layerCrop(img.Bounds(), data)
+	iw, ih := float64(src.Dx()), float64(src.Dy())
+	x, y, w, h := layerBox(data, 0, 0)
+
+	fit := getString(data, "fit", "cover")
+	if w <= 0 || h <= 0 {
+		// Без рамки: размер картинки в масштабе scale
+		scale := getFloat(data, "scale", 1.0)
+		if scale <= 0 {
+			return nil, 0, 0, fmt.Errorf("scale must be positive")
+		}
+		w, h, fit = iw*scale, ih*scale, "fill"
+	}
+	focusX := max(0, min(getFloat(data, "focus_x", 0.5), 1))
+	focusY := max(0, min(getFloat(data, "focus_y", 0.5), 1))
+
+	var crop image.Rectangle // Какая часть исходной картинки попадает на холст
+	switch fit {
+	case "fill":
+		crop = src
+	case "contain":
+		scale := math.Min(w/iw, h/ih)
+		x, y = x+(w-iw*scale)/2, y+(h-ih*scale)/2
+		w, h = iw*scale, ih*scale
+		crop = src
+	case "cover":
+		scale := math.Max(w/iw, h/ih)
+		crop = focusCrop(src, w/scale, h/scale, focusX, focusY)
+	case "none":
+		crop = focusCrop(src, math.Min(w, iw), math.Min(h, ih), focusX, focusY)
+		x, y = x+(w-float64(crop.Dx()))/2, y+(h-float64(crop.Dy()))/2
+		w, h = float64(crop.Dx()), float64(crop.Dy())
+	default:
+		return nil, 0, 0, fmt.Errorf("unknown image fit %q", fit)
+	}
+
+	tw, th := int(math.Round(w)), int(math.Round(h))
+	if tw <= 0 || th <= 0 {
+		return nil, 0, 0, fmt.Errorf("image layer is empty (%dx%d)", tw, th)
+	}
+	if tw > maxCanvasSide || th > maxCanvasSide {
+		return nil, 0, 0, fmt.Errorf("image layer is too large (%dx%d)", tw, th)
+	}
+	tile = image.NewRGBA(image.Rect(0, 0, tw, th))
+	if crop.Dx() == tw && crop.Dy() == th {
+		xdraw.Copy(tile, image.Point{}, img, crop, xdraw.Src, nil)
+	} else {
+		xdraw.CatmullRom.Scale(tile, tile.Bounds(), img, crop, xdraw.Src, nil)
+	}
+	return tile, x, y, nil
+}
+
+// layerCrop — часть исходной картинки из crop: {"x": …, "y": …, "w": …, "h": …} (по умолчанию вся картинка)
+func layerCrop(bounds image.Rectangle, data map[string]interface{}) image.Rectangle {
+	crop, ok := data["crop"].(map[string]interface{})
+	if !ok {
+		return bounds
+	}
+	x, y, w, h := layerBox(crop, float64(bounds.Dx()), float64(bounds.Dy()))
+	r := image.Rect(int(x), int(y), int(x+w), int(y+h)).Add(bounds.Min).Intersect(bounds)
+	if r.Empty() {
+		return bounds
+	}
+	return r
+}
+
+// focusCrop — прямоугольник w×h внутри src, по возможности с центром в точке focusX, focusY (доли 0..1)
+func focusCrop(src image.Rectangle, w, h, focusX, focusY float64) image.Rectangle {
+	cw := max(1, min(int(math.Round(w)), src.Dx()))
+	ch := max(1, min(int(math.Round(h)), src.Dy()))
+	left := int(math.Round(float64(src.Dx())*focusX)) - cw/2
+	top := int(math.Round(float64(src.Dy())*focusY)) - ch/2
+	left = max(0, min(left, src.Dx()-cw))
+	top = max(0, min(top, src.Dy()-ch))
+	return image.Rect(src.Min.X+left, src.Min.Y+top, src.Min.X+left+cw, src.Min.Y+top+ch)
+}
+
+// flipImage — отражает картинку по горизонтали и/или вертикали (на месте)
+func flipImage(img *image.RGBA, flipX, flipY bool) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if flipX {
+		for y := 0; y < h; y++ {
+			row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+			for l, r := 0, w-1; l < r; l, r = l+1, r-1 {
+				for c := 0; c < 4; c++ {
+					row[l*4+c], row[r*4+c] = row[r*4+c], row[l*4+c]
+				}
+			}
+		}
+	}
+	if flipY {
+		for t, b := 0, h-1; t < b; t, b = t+1, b-1 {
+			top := img.Pix[t*img.Stride : t*img.Stride+w*4]
+			bottom := img.Pix[b*img.Stride : b*img.Stride+w*4]
+			for i := range top {
+				top[i], bottom[i] = bottom[i], top[i]
+			}
+		}
+	}
+}
+
+// maskImage — обрезает углы картинки: mask: circle — эллипс по размеру картинки, radius — скругление
+func maskImage(img *image.RGBA, data map[string]interface{}) image.Image {
+	w, h := float64(img.Rect.Dx()), float64(img.Rect.Dy())
+	radius := max(0, min(getFloat(data, "radius", 0), math.Min(w, h)/2))
+	circle := getString(data, "mask", "") == "circle"
+	if !circle && radius == 0 {
+		return img
+	}
+
+	dc := gg.NewContext(img.Rect.Dx(), img.Rect.Dy())
+	if circle {
+		dc.DrawEllipse(w/2, h/2, w/2, h/2)
+	} else {
+		dc.DrawRoundedRectangle(0, 0, w, h, radius)
+	}
+	dc.Clip()
+	dc.DrawImage(img, 0, 0)
+	return dc.Image()
+}
@@ -0,0 +1,297 @@
+// imagesource.go
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxImageRedirects — сколько перенаправлений допускается при скачивании image_url
+const maxImageRedirects = 5
+
+var (
+	errImageHostNotAllowed = errors.New("image host is not in images.allowed_hosts")
+	errImageTooLarge       = errors.New("image is larger than images.max_bytes")
+)
+
+var (
+	imageMaxBytes     int64       = 10 << 20 // Предел размера картинки из image_url и file_id
+	imageAllowedHosts []string               // Откуда можно скачивать image_url (пусто — ниоткуда)
+	imageCache        *assetCache            // Кэш скачанных картинок (nil — без кэша)
+)
+
+// imageHTTPClient — клиент для image_url; каждое перенаправление тоже проверяется по allowed_hosts
+var imageHTTPClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxImageRedirects {
+			return errors.New("too many redirects")
+		}
+		return checkImageURL(req.URL)
+	},
+}
+
+// initImageSources — настройки скачивания картинок слоёв (вызывается из main.go)
+func initImageSources(cfg ImagesConfig) error {
+	imageMaxBytes = int64(cfg.MaxBytes)
+	imageAllowedHosts = cfg.AllowedHosts
+	imageHTTPClient.Timeout = cfg.FetchTimeout
+	if cfg.CacheDir == "" {
+		return nil
+	}
+	cache, err := newAssetCache(cfg.CacheDir, int64(cfg.CacheMaxBytes))
+	if err != nil {
+		return err
+	}
+	imageCache = cache
+	return nil
+}
+
+// layerImageBytes — байты картинки слоя: image_base64, image_url (или устаревшее url) либо file_id
+func layerImageBytes(ctx context.Context, bot *tgbotapi.BotAPI, data map[string]interface{}) ([]byte, error) {
+	if imageBase64 := getString(data, "image_base64", ""); imageBase64 != "" {
+		imageBytes, err := base64.StdEncoding.DecodeString(imageBase64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode base64: %w", err)
+		}
+		return imageBytes, nil
+	}
+	if imageURL := getString(data, "image_url", getString(data, "url", "")); imageURL != "" {
+		return fetchImageURL(ctx, imageURL)
+	}
+	if fileID := getString(data, "file_id", ""); fileID != "" {
+		return fetchTelegramImage(ctx, bot, fileID)
+	}
+	return nil, fmt.Errorf("no image_base64, image_url or file_id in layer data")
+}
+
+// fetchImageURL — скачивает картинку по ссылке (с кэшем)
+func fetchImageURL(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("bad image_url: %w", err)
+	}
+	if err := checkImageURL(u); err != nil {
+		return nil, err
+	}
+
+	key := "url:" + u.String()
+	if data, ok := imageCache.get(key); ok {
+		return data, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetch image %s: %w", u.Host, err)
+	}
+	resp, err := imageHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch image %s: status %d", u.Host, resp.StatusCode)
+	}
+	if resp.ContentLength > imageMaxBytes {
+		return nil, errImageTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, imageMaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetch image %s: %w", u.Host, err)
+	}
+	if int64(len(data)) > imageMaxBytes {
+		return nil, errImageTooLarge
+	}
+
+	imageCache.put(key, data)
+	return data, nil
+}
+
+// checkImageURL — ссылка http(s) на разрешённый хост
+// В allowed_hosts — имя хоста (example.com) или все его поддомены (*.example.com).
+func checkImageURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("image_url must be http(s), got %q", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range imageAllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", errImageHostNotAllowed, host)
+}
+
+// fetchTelegramImage — скачивает картинку из Telegram по file_id (с кэшем)
+func fetchTelegramImage(ctx context.Context, bot *tgbotapi.BotAPI, fileID string) ([]byte, error) {
+	if bot == nil {
+		return nil, fmt.Errorf("file_id %s: Telegram is not available", fileID)
+	}
+	key := "file_id:" + fileID
+	if data, ok := imageCache.get(key); ok {
+		return data, nil
+	}
+	data, err := downloadTelegramFile(ctx, bot, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > imageMaxBytes {
+		return nil, errImageTooLarge
+	}
+	imageCache.put(key, data)
+	return data, nil
+}
+
+// assetCache — кэш скачанных файлов на диске с адресацией по содержимому
+// blobs/<sha256 содержимого> — сами файлы (одинаковая картинка по разным ссылкам хранится один раз),
+// keys/<sha256 ключа> — ссылка с ключа (image_url или file_id) на файл.
+// Когда файлы занимают больше maxBytes, удаляются те, что дольше всего не использовались.
+type assetCache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+}
+
+// newAssetCache — кэш в каталоге dir (создаётся, если его нет)
+func newAssetCache(dir string, maxBytes int64) (*assetCache, error) {
+	for _, sub := range []string{"blobs", "keys"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("image cache: %w", err)
+		}
+	}
+	return &assetCache{dir: dir, maxBytes: maxBytes}, nil
+}
+
+// get — файл по ключу; nil-кэш всегда промахивается
+func (c *assetCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ref, err := os.ReadFile(c.keyPath(key))
+	if err != nil {
+		return nil, false
+	}
+	blob := c.blobPath(strings.TrimSpace(string(ref)))
+	data, err := os.ReadFile(blob)
+	if err != nil {
+		os.Remove(c.keyPath(key)) // Файл вытеснен — ссылка больше не нужна
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(blob, now, now) // Для вытеснения: давно не использованные удаляются первыми
+	return data, true
+}
+
+// put — сохранить файл под ключом; ошибки записи только логируются (кэш не обязателен)
+func (c *assetCache) put(key string, data []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if _, err := os.Stat(c.blobPath(hash)); err != nil {
+		if err := writeFileAtomic(c.blobPath(hash), data, 0o644); err != nil {
+			slog.Warn("Failed to cache image", "err", err)
+			return
+		}
+	}
+	if err := writeFileAtomic(c.keyPath(key), []byte(hash), 0o644); err != nil {
+		slog.Warn("Failed to cache image", "err", err)
+		return
+	}
+	c.evictLocked()
+}
+
+// evictLocked — удалить давно не использованные файлы сверх maxBytes (0 — без ограничения)
+// Вместе с файлами удаляются ссылки keys/ на них, иначе каталог рос бы с каждой новой ссылкой.
+func (c *assetCache) evictLocked() {
+	if c.maxBytes <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(filepath.Join(c.dir, "blobs"))
+	if err != nil {
+		return
+	}
+	type blob struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var blobs []blob
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		blobs = append(blobs, blob{filepath.Join(c.dir, "blobs", entry.Name()), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].modTime.Before(blobs[j].modTime) })
+	removed := false
+	for _, b := range blobs {
+		if total <= c.maxBytes {
+			break
+		}
+		if os.Remove(b.path) == nil {
+			total -= b.size
+			removed = true
+		}
+	}
+	if removed {
+		c.pruneKeysLocked()
+	}
+}
+
+// pruneKeysLocked — удалить ссылки keys/, чьих файлов больше нет
+func (c *assetCache) pruneKeysLocked() {
+	entries, err := os.ReadDir(filepath.Join(c.dir, "keys"))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(c.dir, "keys", entry.Name())
+		ref, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(c.blobPath(strings.TrimSpace(string(ref)))); errors.Is(err, fs.ErrNotExist) {
+			os.Remove(path)
+		}
+	}
+}
+
+// blobPath — путь к файлу с содержимым
+func (c *assetCache) blobPath(hash string) string {
+	return filepath.Join(c.dir, "blobs", hash)
+}
+
+// keyPath — путь к ссылке с ключа на содержимое
+func (c *assetCache) keyPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, "keys", hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFetchTelegramImageFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		api      roundTripFunc // nil — бота нет
+		download roundTripFunc
+		wantErr  string
+	}{
+		{"no bot", nil, nil, "file_id f1: Telegram is not available"},
+		{"get file fails", failTransport, nil, "get file f1: connection reset by peer"},
+		{"download fails", getFileOK, failTransport, "download file f1: connection reset by peer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(client *http.Client, cache *assetCache) {
+				telegramFileClient, imageCache = client, cache
+			}(telegramFileClient, imageCache)
+			telegramFileClient = &http.Client{Transport: tt.download}
+			cache, err := newAssetCache(t.TempDir(), 1<<20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			imageCache = cache
+
+			bot := testBot(tt.api)
+			if tt.api == nil {
+				bot = nil
+			}
+			layer := Layer{LayerID: "photo", Type: "image", Data: map[string]interface{}{"file_id": "f1", "x": 0.0, "y": 0.0}}
+
+			_, err = layerImageBytes(context.Background(), bot, layer.Data)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("layerImageBytes() error = %v, want %q", err, tt.wantErr)
+			}
+			if _, ok := imageCache.get("file_id:f1"); ok {
+				t.Error("failed download is cached")
+			}
+
+			// Ошибка доходит до отчёта о сборке (и из него — до лога и чата) без токена
+			_, report, err := composeLayers(context.Background(), bot, nil, []Layer{layer})
+			if err != nil {
+				t.Fatalf("composeLayers() error = %v", err)
+			}
+			if len(report.Warnings) != 1 || report.Warnings[0].Reason != "draw_failed" {
+				t.Fatalf("composeLayers() warnings = %+v, want one draw_failed", report.Warnings)
+			}
+			if warning := report.Warnings[0].Err.Error(); strings.Contains(warning, testBotToken) {
+				t.Errorf("render warning leaks the bot token: %s", warning)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// janitor.go
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var (
+	defaultFlowTTL  = 30 * time.Minute           // Время жизни незавершённого сценария по умолчанию
+	flowTTLs        = map[string]time.Duration{} // Переопределения по сценариям (ключ — flowOf)
+	idleStateTTL    = 24 * time.Hour             // Через сколько выгружать из памяти состояние без сценария
+	janitorInterval = time.Minute                // Как часто проверять состояния
+)
+
+// flowTitles — названия сценариев для уведомления о сбросе
+var flowTitles = map[string]string{
+	"nko":              "ввод данных НКО",
+	"text_struct":      "структурированная форма поста",
+	"plan":             "контент-план",
+	"text_free_input":  "генерация текста",
+	"image_desc":       "генерация картинки",
+	"edit_text":        "редактор текста",
+	"post_send_chat":   "отправка поста",
+	"profile_new_name": "создание профиля",
+}
+
+// flowOf — сценарий, к которому относится состояние (ID мастера или имя одношагового состояния)
+func flowOf(state string) string {
+	if ws, ok := wizardSteps[state]; ok {
+		return ws.wizard.ID
+	}
+	return state
+}
+
+// flowTTL — время жизни незавершённого сценария
+func flowTTL(flow string) time.Duration {
+	if ttl, ok := flowTTLs[flow]; ok {
+		return ttl
+	}
+	return defaultFlowTTL
+}
+
+// applyStateTTLs — TTL из настроек states: flow_ttl (по умолчанию для всех сценариев),
+// flow_ttls (по сценариям, например post_send_chat: 5m, nko: 2h), idle_ttl и janitor_interval
+func applyStateTTLs(cfg StatesConfig) {
+	defaultFlowTTL = cfg.FlowTTL
+	idleStateTTL = cfg.IdleTTL
+	janitorInterval = cfg.JanitorInterval
+	flowTTLs = make(map[string]time.Duration, len(cfg.FlowTTLs))
+	for flow, ttl := range cfg.FlowTTLs {
+		flowTTLs[flow] = ttl
+	}
+}
+
+// expireStates — сбрасывает брошенные сценарии и выгружает давно неактивные состояния
+// Вызывается из цикла обработки обновлений в main.go, поэтому не конкурирует с обработчиками.
+func expireStates(bot *tgbotapi.BotAPI) {
+	now := time.Now()
+	expired := make(map[int64]string) // chat ID → сценарий
+
+	mu.Lock()
+	for _, state := range userStates.All() {
+		age := now.Sub(state.UpdatedAt)
+
+		if state.State == "idle" {
+			// Данные НКО лежат в nko_data.json, так что состояние можно просто забыть
+			if age > idleStateTTL {
+				if err := userStates.Delete(state.ChatID); err != nil {
+					slog.Error("Failed to evict state", "chat_id", state.ChatID, "err", err)
+				}
+				untrackStateLocked(state.ChatID)
+			}
+			continue
+		}
+
+		flow := flowOf(state.State)
+		if age <= flowTTL(flow) {
+			continue
+		}
+		state.State = "idle"
+		state.TempData = make(map[string]string)
+		state.UpdatedAt = now
+		if err := userStates.Put(state); err != nil {
+			slog.Error("Failed to reset state", "chat_id", state.ChatID, "err", err)
+		}
+		trackStateLocked(state)
+		expired[state.ChatID] = flow
+	}
+	mu.Unlock()
+
+	for chatID, flow := range expired {
+		slog.Info("State expired", "chat_id", chatID, "flow", flow)
+		title := flowTitles[flow]
+		if title == "" {
+			title = flow
+		}
+		msg := tgbotapi.NewMessage(chatID, "⌛ Сценарий «"+title+"» был сброшен: ты давно не отвечал(а).\n\nНачни заново из меню, когда будет удобно.")
+		msg.ReplyMarkup = MainMenu(ActiveProfileTitle(chatID))
+		bot.Send(msg)
+	}
+}
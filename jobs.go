@@ -0,0 +1,310 @@
+// jobs.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"nko-bot-frontend/agent"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var (
+	jobWorkers     = 4   // Сколько задач генерации выполняется одновременно
+	jobQueueSize   = 100 // Сколько задач может ждать своей очереди
+	maxJobsPerUser = 2   // Сколько задач одного пользователя может быть в работе (в очереди и выполняется)
+)
+
+const (
+	jobActionInterval = 4 * time.Second // Как часто повторять статус «печатает…» (Telegram показывает его ~5 секунд)
+	jobNotifyTimeout  = 5 * time.Second // Сколько ждать при остановке, пока прерванные задачи сообщат о перезапуске
+)
+
+// jobRestartText — сообщение для задач, которые не успели выполниться до остановки бота
+const jobRestartText = "🔄 Бот перезапускается, и «%s» не успела завершиться.\n\nПовтори запрос через минуту."
+
+// Job — фоновая задача генерации
+// Бот сразу отвечает сообщением «⏳ …», а после выполнения заменяет его результатом.
+type Job struct {
+	ID        string
+	ChatID    int64
+	Title     string // Что делаем, например «Генерация текста»
+	Action    string // Статус в чате: tgbotapi.ChatTyping или tgbotapi.ChatUploadPhoto
+	ErrorText string // Начало сообщения об ошибке, например «Ошибка генерации текста»
+	Hint      string // Подсказка после ошибки
+	Run       func(ctx context.Context, job *Job, bot *tgbotapi.BotAPI) error
+	RequestID string // ID запроса обновления, создавшего задачу (по умолчанию — из контекста EnqueueJob)
+
+	MessageID int       // Сообщение «⏳ …», которое заменяется результатом
+	CreatedAt time.Time // Когда поставлена в очередь
+	StartedAt time.Time // Когда начала выполняться (нулевое — ещё в очереди)
+
+	cancel   context.CancelFunc
+	canceled bool
+}
+
+// jobQueue — очередь задач и пул исполнителей
+type jobQueue struct {
+	mu     sync.Mutex
+	queue  chan *Job
+	active map[string]*Job // Задачи в очереди и в работе
+	nextID int
+	closed bool // Бот останавливается: новые задачи не принимаются
+
+	wg        sync.WaitGroup     // Исполнители
+	ctx       context.Context    // Общий контекст задач, отменяется при остановке
+	cancelAll context.CancelFunc // Прервать все задачи
+}
+
+var jobs = &jobQueue{active: make(map[string]*Job)}
+
+// startJobWorkers — запускает пул исполнителей задач с настройками jobs (вызывается из main.go)
+func startJobWorkers(bot *tgbotapi.BotAPI, cfg JobsConfig) {
+	jobWorkers, jobQueueSize, maxJobsPerUser = cfg.Workers, cfg.QueueSize, cfg.PerUser
+	jobs.queue = make(chan *Job, jobQueueSize)
+	jobs.ctx, jobs.cancelAll = context.WithCancel(context.Background())
+	jobs.wg.Add(jobWorkers)
+	for i := 0; i < jobWorkers; i++ {
+		go jobs.worker(bot)
+	}
+	slog.Info("Started job workers", "workers", jobWorkers, "queue", jobQueueSize, "per_user", maxJobsPerUser)
+}
+
+// EnqueueJob — ставит задачу в очередь и отвечает пользователю «⏳ …»
+// Если у пользователя слишком много задач или очередь переполнена, задача не ставится (false).
+func EnqueueJob(ctx context.Context, job *Job, bot *tgbotapi.BotAPI) bool {
+	chatID := job.ChatID
+	if job.RequestID == "" {
+		job.RequestID = agent.RequestID(ctx)
+	}
+	if n := len(UserJobs(chatID)); n >= maxJobsPerUser {
+		bot.Send(tgbotapi.NewMessage(chatID, "⏳ У тебя уже "+strconv.Itoa(n)+" задачи в работе. Дождись результата или отмени лишние: /jobs"))
+		return false
+	}
+
+	jobs.mu.Lock()
+	closed := jobs.closed
+	jobs.nextID++
+	job.ID = strconv.Itoa(jobs.nextID)
+	job.CreatedAt = time.Now()
+	jobs.mu.Unlock()
+	if closed {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(jobRestartText, job.Title)))
+		return false
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "⏳ "+job.Title+"…\n\nЭто может занять до минуты, а пока можно пользоваться ботом. Все задачи: /jobs")
+	msg.ReplyMarkup = JobProgressInline(job.ID)
+	sent, err := bot.Send(msg)
+	if err != nil {
+		job.logger().Error("Failed to send progress message", "err", err)
+		return false
+	}
+	job.MessageID = sent.MessageID
+
+	jobs.mu.Lock()
+	if jobs.closed {
+		jobs.mu.Unlock()
+		job.Reply(bot, fmt.Sprintf(jobRestartText, job.Title))
+		return false
+	}
+	select {
+	case jobs.queue <- job:
+		jobs.active[job.ID] = job
+		jobs.mu.Unlock()
+		bot.Request(tgbotapi.NewChatAction(chatID, job.Action))
+		return true
+	default:
+		jobs.mu.Unlock()
+		job.Reply(bot, "❌ "+job.ErrorText+": бот сейчас перегружен. Попробуй через пару минут.")
+		return false
+	}
+}
+
+// logger — логгер задачи (с request_id обновления, из которого она создана)
+func (job *Job) logger() *slog.Logger {
+	return slog.With("request_id", job.RequestID, "job_id", job.ID, "job", job.Title, "chat_id", job.ChatID)
+}
+
+// UserJobs — задачи пользователя в очереди и в работе (по порядку постановки)
+func UserJobs(chatID int64) []Job {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+
+	var list []Job
+	for _, job := range jobs.active {
+		if job.ChatID == chatID {
+			list = append(list, *job)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// counts — сколько задач ждут в очереди и сколько выполняются (для метрик)
+func (q *jobQueue) counts() (queued, running int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range q.active {
+		if job.StartedAt.IsZero() {
+			queued++
+		} else {
+			running++
+		}
+	}
+	return queued, running
+}
+
+// CancelJob — отменить задачу пользователя; false, если задача уже завершилась
+func CancelJob(chatID int64, id string, bot *tgbotapi.BotAPI) bool {
+	jobs.mu.Lock()
+	job, ok := jobs.active[id]
+	if !ok || job.ChatID != chatID {
+		jobs.mu.Unlock()
+		return false
+	}
+	job.canceled = true
+	delete(jobs.active, id)
+	running := job.cancel != nil
+	if running {
+		job.cancel()
+	}
+	jobs.mu.Unlock()
+
+	// Выполняющаяся задача сама сообщит об отмене, когда прервётся
+	if !running {
+		job.Reply(bot, "✖️ "+job.Title+" — отменено.")
+	}
+	return true
+}
+
+// Reply — заменить сообщение «⏳ …» текстом (кнопка отмены при этом убирается)
+func (job *Job) Reply(bot *tgbotapi.BotAPI, text string) {
+	if _, err := bot.Send(tgbotapi.NewEditMessageText(job.ChatID, job.MessageID, text)); err != nil {
+		// Сообщение могли удалить — отправляем результат заново
+		bot.Send(tgbotapi.NewMessage(job.ChatID, text))
+	}
+}
+
+// worker — исполнитель задач из очереди
+func (q *jobQueue) worker(bot *tgbotapi.BotAPI) {
+	defer q.wg.Done()
+	for job := range q.queue {
+		if q.ctx.Err() != nil {
+			// Бот останавливается, а задача так и не началась
+			q.mu.Lock()
+			delete(q.active, job.ID)
+			canceled := job.canceled
+			q.mu.Unlock()
+			if !canceled {
+				job.Reply(bot, fmt.Sprintf(jobRestartText, job.Title))
+			}
+			continue
+		}
+		q.run(job, bot)
+	}
+}
+
+// run — выполнить задачу и показать результат или ошибку
+func (q *jobQueue) run(job *Job, bot *tgbotapi.BotAPI) {
+	ctx, cancel := context.WithCancel(agent.WithRequestID(q.ctx, job.RequestID))
+	defer cancel()
+
+	q.mu.Lock()
+	if job.canceled {
+		q.mu.Unlock()
+		return
+	}
+	job.cancel = cancel
+	job.StartedAt = time.Now()
+	q.mu.Unlock()
+
+	job.logger().Debug("Job started", "queued_for", job.StartedAt.Sub(job.CreatedAt).Round(time.Millisecond))
+	stopAction := keepChatAction(job, bot)
+	err := job.Run(ctx, job, bot)
+	stopAction()
+
+	q.mu.Lock()
+	delete(q.active, job.ID)
+	canceled := job.canceled
+	q.mu.Unlock()
+
+	switch {
+	case canceled && (err == nil || errors.Is(err, context.Canceled)):
+		if err != nil {
+			job.Reply(bot, "✖️ "+job.Title+" — отменено.")
+		}
+	case err != nil && q.ctx.Err() != nil:
+		job.logger().Warn("Job interrupted by shutdown")
+		job.Reply(bot, fmt.Sprintf(jobRestartText, job.Title))
+	case err != nil:
+		job.logger().Error("Job failed", "err", err)
+		job.Reply(bot, "❌ "+job.ErrorText+": "+agentErrorText(err)+"\n\n"+job.Hint)
+	default:
+		job.logger().Debug("Job finished", "took", time.Since(job.StartedAt).Round(time.Millisecond))
+	}
+}
+
+// stopJobs — остановка: новые задачи не принимаются, начатые и ожидающие выполняются в течение timeout
+// Что не успело — прерывается, а пользователи получают сообщение о перезапуске.
+func stopJobs(timeout time.Duration) {
+	jobs.mu.Lock()
+	if jobs.queue == nil || jobs.closed {
+		jobs.mu.Unlock()
+		return
+	}
+	jobs.closed = true
+	close(jobs.queue)
+	pending := len(jobs.active)
+	jobs.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		jobs.wg.Wait()
+		close(done)
+	}()
+
+	slog.Info("Waiting for jobs", "timeout", timeout, "pending", pending)
+	select {
+	case <-done:
+		slog.Info("All jobs finished")
+		return
+	case <-time.After(timeout):
+	}
+
+	jobs.mu.Lock()
+	pending = len(jobs.active)
+	jobs.mu.Unlock()
+	slog.Warn("Jobs did not finish in time, interrupting", "pending", pending, "timeout", timeout)
+	jobs.cancelAll()
+	select {
+	case <-done:
+	case <-time.After(jobNotifyTimeout):
+		slog.Warn("Some jobs did not stop in time", "timeout", jobNotifyTimeout)
+	}
+}
+
+// keepChatAction — показывает «печатает…» / «отправляет фото…», пока задача выполняется
+func keepChatAction(job *Job, bot *tgbotapi.BotAPI) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(jobActionInterval)
+		defer ticker.Stop()
+		for {
+			bot.Request(tgbotapi.NewChatAction(job.ChatID, job.Action))
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() { close(done) }
+}
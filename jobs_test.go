@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// fakeTelegram — Bot API в памяти: запоминает отправленные тексты, на всё отвечает успехом
+type fakeTelegram struct {
+	mu    sync.Mutex
+	texts []string // text из sendMessage и editMessageText по порядку
+}
+
+func (f *fakeTelegram) roundTrip(req *http.Request) (*http.Response, error) {
+	req.ParseForm()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if method := path.Base(req.URL.Path); method == "sendMessage" || method == "editMessageText" {
+		f.texts = append(f.texts, req.PostForm.Get("text"))
+	}
+	return jsonResponse(fmt.Sprintf(`{"ok":true,"result":{"message_id":%d,"chat":{"id":1},"date":0}}`, len(f.texts))), nil
+}
+
+// sent — есть ли среди отправленных текстов текст с подстрокой s
+func (f *fakeTelegram) sent(s string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, text := range f.texts {
+		if strings.Contains(text, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// useTestJobs — пустая очередь задач с исполнителями cfg и бот на fakeTelegram
+func useTestJobs(t *testing.T, cfg JobsConfig) (*tgbotapi.BotAPI, *fakeTelegram) {
+	t.Helper()
+	oldJobs, oldWorkers, oldQueueSize, oldPerUser := jobs, jobWorkers, jobQueueSize, maxJobsPerUser
+	t.Cleanup(func() {
+		stopJobs(10 * time.Millisecond) // Задачи, которые ждут отмены, прерываются сразу
+		jobs, jobWorkers, jobQueueSize, maxJobsPerUser = oldJobs, oldWorkers, oldQueueSize, oldPerUser
+	})
+	telegram := &fakeTelegram{}
+	bot := &tgbotapi.BotAPI{Token: testBotToken, Client: &http.Client{Transport: roundTripFunc(telegram.roundTrip)}}
+	bot.SetAPIEndpoint(tgbotapi.APIEndpoint)
+	jobs = &jobQueue{active: make(map[string]*Job)}
+	startJobWorkers(bot, cfg)
+	return bot, telegram
+}
+
+// testJob — задача пользователя chatID, которая сообщает о старте в started и выполняет run
+func testJob(chatID int64, started chan<- string, run func(ctx context.Context) error) *Job {
+	return &Job{
+		ChatID:    chatID,
+		Title:     "Тестовая задача",
+		ErrorText: "Ошибка теста",
+		Run: func(ctx context.Context, job *Job, bot *tgbotapi.BotAPI) error {
+			if started != nil {
+				started <- job.ID
+			}
+			return run(ctx)
+		},
+	}
+}
+
+// waitForCtx — задача, которая выполняется до отмены
+func waitForCtx(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestEnqueueJobLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       JobsConfig
+		chats     []int64 // Чьи задачи ставятся по порядку (все ждут отмены)
+		wantQueue []bool  // Приняты ли они
+		wantText  string  // Что ответили на отклонённую задачу
+	}{
+		{"queue full", JobsConfig{Workers: 1, QueueSize: 1, PerUser: 10}, []int64{1, 2, 3}, []bool{true, true, false}, "бот сейчас перегружен"},
+		{"per-user limit", JobsConfig{Workers: 1, QueueSize: 10, PerUser: 2}, []int64{1, 1, 1, 2}, []bool{true, true, false, true}, "У тебя уже 2 задачи в работе"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot, telegram := useTestJobs(t, tt.cfg)
+			started := make(chan string, len(tt.chats))
+			for i, chatID := range tt.chats {
+				got := EnqueueJob(context.Background(), testJob(chatID, started, waitForCtx), bot)
+				if got != tt.wantQueue[i] {
+					t.Fatalf("EnqueueJob(#%d, chat %d) = %v, want %v", i+1, chatID, got, tt.wantQueue[i])
+				}
+				if i == 0 {
+					<-started // Первую задачу взял исполнитель, дальше очередь заполняется
+				}
+			}
+			if !telegram.sent(tt.wantText) {
+				t.Errorf("no %q reply in %q", tt.wantText, telegram.texts)
+			}
+		})
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	bot, telegram := useTestJobs(t, JobsConfig{Workers: 1, QueueSize: 10, PerUser: 10})
+	started := make(chan string, 2)
+	finished := make(chan error, 2)
+	run := func(ctx context.Context) error {
+		err := waitForCtx(ctx)
+		finished <- err
+		return err
+	}
+	EnqueueJob(context.Background(), testJob(1, started, run), bot)
+	EnqueueJob(context.Background(), testJob(1, started, run), bot)
+	runningID := <-started
+	list := UserJobs(1)
+	if len(list) != 2 || list[0].ID != runningID || list[0].StartedAt.IsZero() || !list[1].StartedAt.IsZero() {
+		t.Fatalf("UserJobs() = %+v, want one running and one queued", list)
+	}
+	queuedID := list[1].ID
+
+	if CancelJob(2, runningID, bot) {
+		t.Error("CancelJob() for another user's job = true")
+	}
+	// Сначала ожидающую: иначе исполнитель возьмёт её, как только освободится
+	if !CancelJob(1, queuedID, bot) {
+		t.Fatal("CancelJob(queued) = false")
+	}
+	if CancelJob(1, queuedID, bot) {
+		t.Error("CancelJob() for a canceled job = true")
+	}
+	if !CancelJob(1, runningID, bot) {
+		t.Fatal("CancelJob(running) = false")
+	}
+	select {
+	case err := <-finished:
+		if err != context.Canceled {
+			t.Errorf("running job finished with %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("running job was not canceled")
+	}
+
+	stopJobs(time.Second)
+	select {
+	case id := <-started:
+		t.Errorf("canceled queued job %s was started", id)
+	default:
+	}
+	if n := len(UserJobs(1)); n != 0 {
+		t.Errorf("UserJobs() after cancel = %d jobs, want 0", n)
+	}
+	if !telegram.sent("Тестовая задача — отменено.") {
+		t.Errorf("no cancel reply in %q", telegram.texts)
+	}
+}
+
+func TestStopJobs(t *testing.T) {
+	t.Run("drains queued jobs", func(t *testing.T) {
+		bot, telegram := useTestJobs(t, JobsConfig{Workers: 1, QueueSize: 10, PerUser: 10})
+		var mu sync.Mutex
+		done := 0
+		for i := 0; i < 3; i++ {
+			EnqueueJob(context.Background(), testJob(int64(i+1), nil, func(ctx context.Context) error {
+				time.Sleep(10 * time.Millisecond)
+				mu.Lock()
+				done++
+				mu.Unlock()
+				return nil
+			}), bot)
+		}
+
+		stopJobs(time.Second)
+		if done != 3 {
+			t.Errorf("finished jobs = %d, want 3", done)
+		}
+		if EnqueueJob(context.Background(), testJob(1, nil, waitForCtx), bot) {
+			t.Error("EnqueueJob() after stopJobs = true")
+		}
+		if !telegram.sent("Бот перезапускается") {
+			t.Errorf("no restart reply for a job after stop in %q", telegram.texts)
+		}
+	})
+
+	t.Run("interrupts jobs after timeout", func(t *testing.T) {
+		bot, telegram := useTestJobs(t, JobsConfig{Workers: 1, QueueSize: 10, PerUser: 10})
+		started := make(chan string, 2)
+		EnqueueJob(context.Background(), testJob(1, started, waitForCtx), bot)
+		EnqueueJob(context.Background(), testJob(2, started, waitForCtx), bot)
+		<-started
+
+		begin := time.Now()
+		stopJobs(50 * time.Millisecond)
+		if took := time.Since(begin); took > jobNotifyTimeout {
+			t.Errorf("stopJobs() took %v", took)
+		}
+		select {
+		case id := <-started:
+			t.Errorf("queued job %s was started after shutdown", id)
+		default:
+		}
+		if n := len(UserJobs(1)) + len(UserJobs(2)); n != 0 {
+			t.Errorf("jobs left after stopJobs = %d, want 0", n)
+		}
+		telegram.mu.Lock()
+		defer telegram.mu.Unlock()
+		restarts := 0
+		for _, text := range telegram.texts {
+			if strings.Contains(text, "Бот перезапускается") {
+				restarts++
+			}
+		}
+		if restarts != 2 {
+			t.Errorf("restart replies = %d, want 2 (running and queued job): %q", restarts, telegram.texts)
+		}
+	})
+}
+
+func TestJobFailureReply(t *testing.T) {
+	bot, telegram := useTestJobs(t, JobsConfig{Workers: 1, QueueSize: 10, PerUser: 10})
+	job := testJob(1, nil, func(ctx context.Context) error { return errors.New("нет ответа") })
+	job.Hint = "Попробуй ещё раз."
+	EnqueueJob(context.Background(), job, bot)
+	stopJobs(time.Second)
+	if !telegram.sent("❌ Ошибка теста: нет ответа\n\nПопробуй ещё раз.") {
+		t.Errorf("no failure reply in %q", telegram.texts)
+	}
+}
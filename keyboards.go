@@ -1,23 +1,71 @@
 package main
 
-import tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-
-// MainMenu — основное меню с функциями ТЗ
-func MainMenu() tgbotapi.ReplyKeyboardMarkup {
-	return tgbotapi.NewReplyKeyboard(
-		tgbotapi.NewKeyboardButtonRow(
-			tgbotapi.NewKeyboardButton("Генерация текста"),
-			tgbotapi.NewKeyboardButton("Генерация картинки"),
-		),
-		tgbotapi.NewKeyboardButtonRow(
-			tgbotapi.NewKeyboardButton("Редактор текста"),
-			tgbotapi.NewKeyboardButton("Контент-план"),
-		),
-		tgbotapi.NewKeyboardButtonRow(
-			tgbotapi.NewKeyboardButton("Ввести данные НКО"),
-			tgbotapi.NewKeyboardButton("Помощь"),
-		),
-	)
+import (
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// profileButtonPrefix — начало текста кнопки профилей в главном меню (по нему распознаём нажатие)
+const profileButtonPrefix = "🏢 Профил"
+
+// mainMenuButtons — кнопки главного меню (по две в строке)
+// Нажатие любой из них прерывает незавершённый сценарий, а не считается ответом на вопрос.
+var mainMenuButtons = []string{
+	"Генерация текста", "Генерация картинки",
+	"Редактор текста", "Контент-план",
+	"Ввести данные НКО", "Помощь",
+}
+
+// isMainMenuButton — является ли текст нажатием кнопки главного меню
+func isMainMenuButton(text string) bool {
+	if strings.HasPrefix(text, profileButtonPrefix) {
+		return true
+	}
+	for _, button := range mainMenuButtons {
+		if text == button {
+			return true
+		}
+	}
+	return false
+}
+
+// menuButtonEnabled — не выключена ли функция кнопки главного меню в настройках features
+func menuButtonEnabled(button string) bool {
+	switch button {
+	case "Генерация картинки":
+		return config.Features.ImageGeneration
+	case "Редактор текста":
+		return config.Features.TextEditor
+	case "Контент-план":
+		return config.Features.ContentPlan
+	}
+	return true
+}
+
+// MainMenu — основное меню с функциями ТЗ (последняя строка показывает активный профиль НКО)
+// Выключенные в features функции в меню не показываются.
+func MainMenu(activeProfile string) tgbotapi.ReplyKeyboardMarkup {
+	profileButton := profileButtonPrefix + "и НКО"
+	if activeProfile != "" {
+		profileButton = profileButtonPrefix + "ь: " + activeProfile
+	}
+
+	var buttons []tgbotapi.KeyboardButton
+	for _, button := range mainMenuButtons {
+		if menuButtonEnabled(button) {
+			buttons = append(buttons, tgbotapi.NewKeyboardButton(button))
+		}
+	}
+	var rows [][]tgbotapi.KeyboardButton
+	for i := 0; i < len(buttons); i += 2 {
+		rows = append(rows, buttons[i:min(i+2, len(buttons))])
+	}
+	rows = append(rows, tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButton(profileButton),
+	))
+	return tgbotapi.NewReplyKeyboard(rows...)
 }
 
 // YesNoInline — inline-кнопки для да/нет (например, для опроса НКО)
@@ -40,62 +88,203 @@ func TextModesInline() tgbotapi.InlineKeyboardMarkup {
 	)
 }
 
-// StylesInline — выбор стиля поста (рекомендация ТЗ для креатива)
-func StylesInline() tgbotapi.InlineKeyboardMarkup {
+// WizardStepInline — клавиатура шага мастера: варианты ответа, «оставить как есть» и навигация
+func WizardStepInline(step WizardStep, hasCurrent bool, canGoBack bool) tgbotapi.InlineKeyboardMarkup {
+	columns := step.Columns
+	if columns <= 0 {
+		columns = 2
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for _, option := range step.Options {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(option.Label, option.Callback))
+		if len(row) == columns {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	if hasCurrent {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("➡️ Оставить как есть", wizardKeepCallback),
+		))
+	}
+
+	navigation := tgbotapi.NewInlineKeyboardRow()
+	if canGoBack {
+		navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", wizardBackCallback))
+	}
+	navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("✖️ Отмена", cancelCallback))
+	rows = append(rows, navigation)
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// CanvasPresetsInline — выбор формата картинки перед её генерацией (по два в строке) и отмена
+func CanvasPresetsInline() tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := 0; i < len(canvasPresets); i += 2 {
+		row := tgbotapi.NewInlineKeyboardRow()
+		for _, preset := range canvasPresets[i:min(i+2, len(canvasPresets))] {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(preset.Title, canvasCallbackPrefix+preset.Name))
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✖️ Отмена", cancelCallback),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// CancelInline — кнопка отмены для одношаговых вопросов (описание картинки, текст для редактора и т.д.)
+func CancelInline() tgbotapi.InlineKeyboardMarkup {
 	return tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Разговорный", "style_conversational"),
-			tgbotapi.NewInlineKeyboardButtonData("Официальный", "style_formal"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Художественный", "style_artistic"),
-			tgbotapi.NewInlineKeyboardButtonData("Эмоциональный", "style_emotional"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Информационный", "style_informational"),
-			tgbotapi.NewInlineKeyboardButtonData("Призыв к действию", "style_call_to_action"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Благодарственный", "style_gratitude"),
-			tgbotapi.NewInlineKeyboardButtonData("Дружелюбный", "style_friendly"),
+			tgbotapi.NewInlineKeyboardButtonData("✖️ Отмена", cancelCallback),
 		),
 	)
 }
 
-// ContentPlanPeriodInline — выбор периода для контент-плана
-func ContentPlanPeriodInline() tgbotapi.InlineKeyboardMarkup {
+// PostActionInline — действия с готовым постом
+func PostActionInline(postID string) tgbotapi.InlineKeyboardMarkup {
 	return tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("7 дней", "plan_7"),
-			tgbotapi.NewInlineKeyboardButtonData("14 дней", "plan_14"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("30 дней", "plan_30"),
-			tgbotapi.NewInlineKeyboardButtonData("Свой вариант", "plan_custom"),
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Перегенерировать", "post_regenerate_"+postID),
+			tgbotapi.NewInlineKeyboardButtonData("📤 Отправить", "post_send_"+postID),
 		),
 	)
 }
 
-// ContentPlanFrequencyInline — выбор частоты публикаций для контент-плана
-func ContentPlanFrequencyInline() tgbotapi.InlineKeyboardMarkup {
+// ProfilesInline — список профилей НКО (активный отмечен галочкой) и управление ими
+func ProfilesInline(profiles []NKOProfile, active string) tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(profiles)+1)
+	for _, profile := range profiles {
+		title := profile.Title
+		if profile.ID == active {
+			title = "✅ " + title
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(title, "prof_sw_"+profile.ID),
+		))
+	}
+	actions := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("➕ Новый профиль", "prof_new"),
+	)
+	if len(profiles) > 0 {
+		actions = append(actions, tgbotapi.NewInlineKeyboardButtonData("🗑 Удалить", "prof_del"))
+	}
+	if len(profiles) > 0 && config.Features.Workspaces {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("👥 Команда", "ws_team"),
+			tgbotapi.NewInlineKeyboardButtonData("📰 Посты команды", "ws_posts"),
+		))
+	}
+	rows = append(rows, actions)
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// ProfilesDeleteInline — выбор профиля для удаления
+func ProfilesDeleteInline(profiles []NKOProfile) tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(profiles)+1)
+	for _, profile := range profiles {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🗑 "+profile.Title, "prof_del_"+profile.ID),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("◀️ К списку профилей", "prof_menu"),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// ProfileDeleteConfirmInline — подтверждение удаления профиля
+func ProfileDeleteConfirmInline(profileID string) tgbotapi.InlineKeyboardMarkup {
 	return tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Ежедневно", "freq_daily"),
-			tgbotapi.NewInlineKeyboardButtonData("Через день", "freq_every_other"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("2 раза в неделю", "freq_twice_week"),
-			tgbotapi.NewInlineKeyboardButtonData("3 раза в неделю", "freq_thrice_week"),
+			tgbotapi.NewInlineKeyboardButtonData("Да, удалить", "prof_delok_"+profileID),
+			tgbotapi.NewInlineKeyboardButtonData("Отмена", "prof_menu"),
 		),
 	)
 }
 
-// PostActionInline — действия с готовым постом
-func PostActionInline(postID string) tgbotapi.InlineKeyboardMarkup {
+// TeamInline — действия с командой: приглашения и исключение участников (только для владельца)
+func TeamInline(workspace NKOProfile, chatID int64) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if workspace.Owner == chatID {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("➕ Редактор", "ws_inv_editor"),
+			tgbotapi.NewInlineKeyboardButtonData("➕ Наблюдатель", "ws_inv_viewer"),
+		))
+		for memberID, member := range workspace.Members {
+			if memberID == chatID {
+				continue
+			}
+			name := member.Name
+			if name == "" {
+				name = strconv.FormatInt(memberID, 10)
+			}
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("✖️ Исключить "+name, "ws_kick_"+strconv.FormatInt(memberID, 10)),
+			))
+		}
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("📰 Посты команды", "ws_posts"),
+		tgbotapi.NewInlineKeyboardButtonData("◀️ К профилям", "prof_menu"),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// TeamPostsInline — последние посты команды (новые сверху)
+func TeamPostsInline(posts []SharedPost) tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(posts))
+	for i := len(posts) - 1; i >= 0; i-- {
+		post := posts[i]
+		title := []rune(post.MainText)
+		if len(title) > 40 {
+			title = append(title[:40], '…')
+		}
+		label := post.CreatedAt.Format("02.01 15:04") + " " + string(title)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, "ws_post_"+post.PostID),
+		))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// ChannelsInline — каналы, куда команда уже публиковала посты, и отмена
+func ChannelsInline(channels []string) tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(channels)+1)
+	for i, channel := range channels {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📢 "+channel, "post_to_"+strconv.Itoa(i)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✖️ Отмена", cancelCallback),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// JobProgressInline — кнопка отмены под сообщением «⏳ …»
+func JobProgressInline(jobID string) tgbotapi.InlineKeyboardMarkup {
 	return tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🔄 Перегенерировать", "post_regenerate_"+postID),
-			tgbotapi.NewInlineKeyboardButtonData("📤 Отправить", "post_send_"+postID),
+			tgbotapi.NewInlineKeyboardButtonData("✖️ Отменить", "job_cancel_"+jobID),
 		),
 	)
 }
+
+// JobsInline — отмена задач пользователя (по одной кнопке на задачу)
+func JobsInline(list []Job) tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(list))
+	for i, job := range list {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✖️ Отменить "+strconv.Itoa(i+1)+". "+job.Title, "jobs_cancel_"+job.ID),
+		))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
@@ -0,0 +1,75 @@
+// logging.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"nko-bot-frontend/agent"
+)
+
+// logLevel — текущий уровень лога; задаётся log.level и меняется на лету через /loglevel
+var logLevel = new(slog.LevelVar)
+
+func init() {
+	httpMux.HandleFunc("/loglevel", handleLogLevel)
+}
+
+// initLogging — логгер slog по настройкам log: уровень и формат (text или json)
+// Сообщения стандартного пакета log после этого тоже идут через slog (с уровнем Info).
+func initLogging(cfg LogConfig) {
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.Level)) // Уровень уже проверен в Config.Validate
+	logLevel.Set(level)
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if cfg.Format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// requestLogger — логгер с request_id (correlation ID) из контекста обновления
+// ID кладёт в контекст HandleUpdate; задачи получают его через EnqueueJob, агент — в заголовке и конверте.
+func requestLogger(ctx context.Context) *slog.Logger {
+	return slog.With("request_id", agent.RequestID(ctx))
+}
+
+// handleLogLevel — текущий уровень лога (GET) и его смена без перезапуска (PUT, тело — debug/info/warn/error)
+// Требует заголовок Authorization: Bearer <log.admin_token>; без токена в настройках endpoint выключен.
+func handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	token := config.Log.AdminToken
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		body, _ := io.ReadAll(io.LimitReader(r.Body, 64))
+		var level slog.Level
+		if err := level.UnmarshalText(bytes.TrimSpace(body)); err != nil {
+			http.Error(w, "expected debug, info, warn or error", http.StatusBadRequest)
+			return
+		}
+		logLevel.Set(level)
+		slog.Warn("Log level changed", "level", level, "remote_addr", r.RemoteAddr)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(strings.ToLower(logLevel.Level().String())))
+}
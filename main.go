@@ -1,46 +1,112 @@
 package main
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"nko-bot-frontend/agent"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	// Загрузка .env (сначала текущая директория, потом абсолютный путь)
-	err := godotenv.Load()
+	// Загрузка .env из текущей директории (необязательно)
+	envErr := godotenv.Load()
+
+	// Настройки: значения по умолчанию → config.yaml (CONFIG_FILE) → переменные окружения (см. config.go)
+	cfg, err := LoadConfig()
 	if err != nil {
-		// Пробуем абсолютный путь для GoLand
-		err = godotenv.Load("C:\\MainProjects\\nko_bot_frontend\\.env")
-		if err != nil {
-			log.Println("No .env file found")
-		}
+		log.Fatal(err)
 	}
-
-	botToken := os.Getenv("BOT_TOKEN")
-	if botToken == "" {
-		log.Panic("BOT_TOKEN not set")
+	config = cfg
+	initLogging(config.Log)
+	if envErr != nil {
+		slog.Info("No .env file found")
 	}
 
-	bot, err := tgbotapi.NewBotAPI(botToken)
+	bot, err := tgbotapi.NewBotAPI(config.Telegram.Token)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	bot.Debug = true
-	log.Printf("Authorized on account %s", bot.Self.UserName)
+	bot.Debug = config.Telegram.Debug
+	slog.Info("Authorized", "account", bot.Self.UserName)
+
+	// Инициализация хранилища данных (storage.state_store: memory|file)
+	if err := InitDB(config.Storage); err != nil {
+		log.Panic(err)
+	}
+
+	// Шрифты текстовых слоёв: встроенный Go + каталог fonts.dir
+	if err := loadFonts(config.Fonts); err != nil {
+		log.Panic(err)
+	}
+	if err := initImageSources(config.Images); err != nil {
+		log.Panic(err)
+	}
+
+	// Клиент AI агента; без адреса бот запустится, но генерация вернёт ошибку
+	// Запросы подписываются секретом agent.secret, agent.verify_responses требует подписанных ответов
+	aiAgent = agent.NewClient(config.Agent.URL,
+		agent.WithTimeouts(config.Agent.Timeout, config.Agent.InitTimeout),
+		agent.WithRetry(agent.RetryPolicy{
+			MaxAttempts: config.Agent.RetryAttempts,
+			BaseDelay:   config.Agent.RetryBaseDelay,
+			MaxDelay:    config.Agent.RetryMaxDelay,
+		}),
+		agent.WithCircuitBreaker(config.Agent.BreakerThreshold, config.Agent.BreakerCooldown),
+		agent.WithSigning(config.Agent.Secret, config.Agent.VerifyResponses),
+		agent.WithObserver(observeAgentRequest),
+		agent.WithPayloadLogLimit(config.Log.PayloadLimit))
+	if config.Agent.URL == "" {
+		slog.Warn("agent.url (AI_AGENT_URL) not set, AI agent requests will fail")
+	}
+	if config.Agent.Secret == "" {
+		slog.Warn("agent.secret (AI_AGENT_SECRET) not set, requests to the AI agent are not signed")
+	}
+
+	// Генерация выполняется в фоне, чтобы долгий запрос одного пользователя не задерживал остальных
+	startJobWorkers(bot, config.Jobs)
 
-	// Инициализация хранилища данных
-	InitDB()
+	// Получение обновлений: server.mode polling (по умолчанию) или webhook (см. server.go)
+	updates, stopUpdates, err := startUpdates(bot)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	// Брошенные сценарии сбрасываются по таймеру (states.flow_ttl, states.flow_ttls, states.idle_ttl)
+	applyStateTTLs(config.States)
+	expireStates(bot) // Состояния, пролежавшие на диске во время простоя бота
+	janitor := time.NewTicker(janitorInterval)
+	defer janitor.Stop()
 
-	updates := bot.GetUpdatesChan(u)
+	// SIGINT/SIGTERM — корректная остановка (см. shutdown.go)
+	shutdownTimeout = config.ShutdownTimeout
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	for update := range updates {
-		HandleUpdate(update, bot) // Теперь вся логика в handlers.go
+	lastUpdateID := 0
+	for {
+		select {
+		case <-ctx.Done():
+			stop() // Повторный Ctrl+C завершит процесс сразу
+			shutdown(bot, updates, stopUpdates, lastUpdateID)
+			return
+		case update, ok := <-updates:
+			if !ok {
+				shutdown(bot, updates, stopUpdates, lastUpdateID)
+				return
+			}
+			HandleUpdate(context.Background(), update, bot) // Теперь вся логика в handlers.go
+			lastUpdateID = update.UpdateID
+		case <-janitor.C:
+			expireStates(bot)
+		}
 	}
 }
@@ -0,0 +1,229 @@
+// metrics.go
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"nko-bot-frontend/agent"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Метрики Prometheus (GET /metrics на встроенном HTTP сервере, см. server.go)
+var (
+	updatesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nkobot_updates_total",
+		Help: "Telegram updates received, by type.",
+	}, []string{"type"})
+
+	commandsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nkobot_commands_total",
+		Help: "Commands and main menu buttons, by name.",
+	}, []string{"command"})
+
+	callbacksTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nkobot_callbacks_total",
+		Help: "Inline button presses, by callback data (IDs replaced with *).",
+	}, []string{"callback"})
+
+	agentRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "nkobot_agent_request_duration_seconds",
+		Help:    "AI agent request latency per attempt, by endpoint and status.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120},
+	}, []string{"endpoint", "status"})
+
+	composeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "nkobot_compose_layers_duration_seconds",
+		Help:    "Time to compose a post image from layers.",
+		Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	})
+
+	renderWarningsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nkobot_render_warnings_total",
+		Help: "Post layers that were skipped or drawn differently than requested, by reason.",
+	}, []string{"reason"})
+
+	userStatesGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nkobot_user_states",
+		Help: "User states held in the state store.",
+	})
+
+	activeFlowsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nkobot_active_flows",
+		Help: "Users in the middle of a flow (state other than idle).",
+	})
+)
+
+// trackedStates — chat ID → в незавершённом ли сценарии (защищено mu)
+// Меняется только в цикле обработки обновлений вместе с хранилищем, а /metrics читает готовые gauge:
+// обходить живые *UserState из HTTP горутины нельзя — обработчики меняют их без блокировки.
+var (
+	trackedStates = make(map[int64]bool)
+	activeFlows   int
+)
+
+func init() {
+	prometheus.MustRegister(updatesTotal, commandsTotal, callbacksTotal, agentRequestDuration, composeDuration, renderWarningsTotal,
+		userStatesGauge, activeFlowsGauge)
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "nkobot_jobs_queued",
+			Help: "Background jobs waiting for a worker.",
+		}, func() float64 {
+			queued, _ := jobs.counts()
+			return float64(queued)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "nkobot_jobs_running",
+			Help: "Background jobs being executed.",
+		}, func() float64 {
+			_, running := jobs.counts()
+			return float64(running)
+		}),
+	)
+	httpMux.Handle("/metrics", promhttp.Handler())
+}
+
+// trackStateLocked — учесть сохранённое состояние в nkobot_user_states и nkobot_active_flows (под mu)
+func trackStateLocked(state *UserState) {
+	active := state.State != "idle"
+	if wasActive, ok := trackedStates[state.ChatID]; ok && wasActive {
+		activeFlows--
+	}
+	if active {
+		activeFlows++
+	}
+	trackedStates[state.ChatID] = active
+	publishStateCountsLocked()
+}
+
+// untrackStateLocked — убрать выгруженное из хранилища состояние (под mu)
+func untrackStateLocked(chatID int64) {
+	if wasActive, ok := trackedStates[chatID]; ok {
+		if wasActive {
+			activeFlows--
+		}
+		delete(trackedStates, chatID)
+		publishStateCountsLocked()
+	}
+}
+
+// resetStateCountsLocked — пересчитать состояния заново (при подключении хранилища, под mu)
+func resetStateCountsLocked() {
+	trackedStates = make(map[int64]bool)
+	activeFlows = 0
+	for _, state := range userStates.All() {
+		trackStateLocked(state)
+	}
+	publishStateCountsLocked()
+}
+
+// publishStateCountsLocked — выставить gauge по trackedStates
+func publishStateCountsLocked() {
+	userStatesGauge.Set(float64(len(trackedStates)))
+	activeFlowsGauge.Set(float64(activeFlows))
+}
+
+// knownCommands — команды, которые считаются по имени (остальные — как other)
+var knownCommands = map[string]bool{"start": true, "help": true, "jobs": true, "cancel": true}
+
+// callbackIDPrefixes — callback'и с ID в конце; в метрике ID заменяется на *, чтобы не плодить серии
+var callbackIDPrefixes = []string{
+	"post_send_", "post_to_", "post_regenerate_",
+	"prof_sw_", "prof_delok_", "prof_del_",
+	"ws_kick_", "ws_post_",
+	"job_cancel_", "jobs_cancel_",
+}
+
+// callbackLabelPattern — callback'и без ID (text_free, style_formal, plan_7, …)
+var callbackLabelPattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// countUpdate — учёт обновления в метриках: тип, команда или кнопка меню, callback
+func countUpdate(update tgbotapi.Update) {
+	updatesTotal.WithLabelValues(updateType(update)).Inc()
+	switch {
+	case update.Message != nil:
+		if label := commandLabel(update.Message); label != "" {
+			commandsTotal.WithLabelValues(label).Inc()
+		}
+	case update.CallbackQuery != nil:
+		callbacksTotal.WithLabelValues(callbackLabel(update.CallbackQuery.Data)).Inc()
+	}
+}
+
+// updateType — тип обновления: message, callback_query или other
+func updateType(update tgbotapi.Update) string {
+	switch {
+	case update.Message != nil:
+		return "message"
+	case update.CallbackQuery != nil:
+		return "callback_query"
+	default:
+		return "other"
+	}
+}
+
+// commandLabel — имя команды (/start) или кнопки главного меню; пусто для обычного текста
+func commandLabel(message *tgbotapi.Message) string {
+	if message.IsCommand() {
+		if knownCommands[message.Command()] {
+			return "/" + message.Command()
+		}
+		return "other"
+	}
+	if strings.HasPrefix(message.Text, profileButtonPrefix) {
+		return profileButtonPrefix + "и НКО"
+	}
+	for _, button := range mainMenuButtons {
+		if message.Text == button {
+			return button
+		}
+	}
+	return ""
+}
+
+// callbackLabel — значение метки callback для callback data
+func callbackLabel(data string) string {
+	for _, prefix := range callbackIDPrefixes {
+		if strings.HasPrefix(data, prefix) {
+			return prefix + "*"
+		}
+	}
+	if callbackLabelPattern.MatchString(data) {
+		return data
+	}
+	return "other"
+}
+
+// observeAgentRequest — задержка попытки запроса к AI агенту (agent.WithObserver)
+func observeAgentRequest(endpoint string, err error, elapsed time.Duration) {
+	agentRequestDuration.WithLabelValues(endpoint, agentStatusLabel(err)).Observe(elapsed.Seconds())
+}
+
+// agentStatusLabel — статус попытки: HTTP код ответа или вид ошибки без ответа
+func agentStatusLabel(err error) string {
+	var statusErr *agent.StatusError
+	var networkErr *agent.NetworkError
+	var signatureErr *agent.SignatureError
+	var decodeErr *agent.DecodeError
+	switch {
+	case err == nil:
+		return "200"
+	case errors.As(err, &statusErr):
+		return strconv.Itoa(statusErr.StatusCode)
+	case errors.As(err, &networkErr):
+		return "network_error"
+	case errors.As(err, &signatureErr):
+		return "bad_signature"
+	case errors.As(err, &decodeErr):
+		return "bad_response"
+	default:
+		return "error"
+	}
+}
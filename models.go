@@ -1,7 +1,11 @@
 // models.go
 package main
 
-import "time"
+import (
+	"time"
+
+	"nko-bot-frontend/agent"
+)
 
 // NKOData — данные об организации
 type NKOData struct {
@@ -11,27 +15,67 @@ type NKOData struct {
 	Style       string
 }
 
+// NKOProfile — профиль НКО (у одного пользователя может быть несколько)
+// Профиль одновременно является рабочим пространством команды: у него есть участники
+// с ролями, приглашения, общая лента постов и список каналов для публикации.
+type NKOProfile struct {
+	ID       string
+	Title    string
+	NKO      NKOData
+	Owner    int64
+	Members  map[int64]WorkspaceMember
+	Invites  map[string]WorkspaceInvite // токен → приглашение
+	Posts    []SharedPost               // последние посты команды (новые в конце)
+	Channels []string                   // чаты, куда команда публиковала посты
+}
+
+// WorkspaceMember — участник рабочего пространства
+type WorkspaceMember struct {
+	Role     string // owner, editor или viewer
+	Name     string
+	JoinedAt time.Time
+}
+
+// WorkspaceInvite — одноразовое приглашение в рабочее пространство (deep-link /start <токен>)
+type WorkspaceInvite struct {
+	Role      string
+	CreatedBy int64
+	ExpiresAt time.Time
+}
+
+// SharedPost — пост, сгенерированный кем-то из команды
+type SharedPost struct {
+	PostID    string
+	Author    int64
+	MainText  string
+	CreatedAt time.Time
+}
+
+// UserProfiles — профили пользователя и активный профиль
+type UserProfiles struct {
+	Active   string
+	Profiles []string // ID профилей в порядке создания
+}
+
+// NKODatabase — содержимое nko_data.json (начиная с версии 2)
+type NKODatabase struct {
+	Version  int
+	Profiles map[string]*NKOProfile
+	Users    map[int64]*UserProfiles
+}
+
 // UserState — состояние пользователя
 type UserState struct {
 	ChatID    int64
 	State     string
-	NKO       NKOData
+	ProfileID string  // Активный профиль НКО
+	NKO       NKOData // Данные активного профиля
 	TempData  map[string]string
 	UpdatedAt time.Time
 }
 
-// PostJSON — формат поста от бэкенда
-type PostJSON struct {
-	PostID         string  `json:"post_id"`
-	PostAuthor     int64   `json:"post_author"`
-	AssignedChatID []int64 `json:"assigned_chat_id"`
-	MainText       string  `json:"main_text"`
-	Content        []Layer `json:"content"`
-}
+// PostJSON — формат поста от бэкенда (описан в пакете agent)
+type PostJSON = agent.Post
 
-type Layer struct {
-	LayerID    string                 `json:"layer_id"`
-	Type       string                 `json:"type"`
-	OrderIndex int                    `json:"order_index"`
-	Data       map[string]interface{} `json:"data"`
-}
+// Layer — слой изображения поста
+type Layer = agent.Layer
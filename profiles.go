@@ -0,0 +1,169 @@
+// profiles.go
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// maxProfilesPerUser — сколько профилей НКО может завести один пользователь
+const maxProfilesPerUser = 10
+
+var (
+	errProfileNotFound  = errors.New("профиль не найден")
+	errTooManyProfiles  = errors.New("достигнут лимит профилей")
+	errEmptyProfileName = errors.New("название профиля не может быть пустым")
+)
+
+// newProfileID — короткий случайный ID профиля (помещается в callback data)
+func newProfileID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// defaultProfileTitle — название профиля по умолчанию (по названию НКО)
+func defaultProfileTitle(nko NKOData) string {
+	if nko.Name != "" {
+		return nko.Name
+	}
+	return "Основной"
+}
+
+// userProfileLocked — профиль пользователя по ID (nil, если у пользователя его нет)
+func userProfileLocked(chatID int64, profileID string) *NKOProfile {
+	user, ok := nkoDB.Users[chatID]
+	if !ok || profileID == "" {
+		return nil
+	}
+	for _, id := range user.Profiles {
+		if id == profileID {
+			return nkoDB.Profiles[id]
+		}
+	}
+	return nil
+}
+
+// activeProfileLocked — активный профиль пользователя (nil, если профилей нет)
+func activeProfileLocked(chatID int64) *NKOProfile {
+	user, ok := nkoDB.Users[chatID]
+	if !ok {
+		return nil
+	}
+	return userProfileLocked(chatID, user.Active)
+}
+
+// createProfileLocked — создаёт профиль и делает его активным (без записи в файл)
+func createProfileLocked(chatID int64, title string) (*NKOProfile, error) {
+	user, ok := nkoDB.Users[chatID]
+	if !ok {
+		user = &UserProfiles{}
+		nkoDB.Users[chatID] = user
+	}
+	if len(user.Profiles) >= maxProfilesPerUser {
+		return nil, errTooManyProfiles
+	}
+
+	profile := &NKOProfile{
+		ID:      newProfileID(),
+		Title:   title,
+		Owner:   chatID,
+		Members: map[int64]WorkspaceMember{chatID: {Role: roleOwner, JoinedAt: time.Now()}},
+	}
+	nkoDB.Profiles[profile.ID] = profile
+	user.Profiles = append(user.Profiles, profile.ID)
+	user.Active = profile.ID
+	return profile, nil
+}
+
+// syncProfile — подтягивает в состояние ID и данные активного профиля
+func syncProfile(state *UserState) {
+	state.ProfileID, state.NKO = LoadNKOData(state.ChatID)
+}
+
+// ListProfiles — профили пользователя и ID активного профиля
+func ListProfiles(chatID int64) ([]NKOProfile, string) {
+	nkoDataMu.Lock()
+	defer nkoDataMu.Unlock()
+
+	user, ok := nkoDB.Users[chatID]
+	if !ok {
+		return nil, ""
+	}
+	profiles := make([]NKOProfile, 0, len(user.Profiles))
+	for _, id := range user.Profiles {
+		if profile, ok := nkoDB.Profiles[id]; ok {
+			profiles = append(profiles, profile.clone())
+		}
+	}
+	return profiles, user.Active
+}
+
+// ActiveProfileTitle — название активного профиля (пустая строка, если профилей нет)
+func ActiveProfileTitle(chatID int64) string {
+	nkoDataMu.Lock()
+	defer nkoDataMu.Unlock()
+
+	if profile := activeProfileLocked(chatID); profile != nil {
+		return profile.Title
+	}
+	return ""
+}
+
+// CreateProfile — создать новый пустой профиль и сделать его активным
+func CreateProfile(chatID int64, title string) (NKOProfile, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return NKOProfile{}, errEmptyProfileName
+	}
+
+	nkoDataMu.Lock()
+	defer nkoDataMu.Unlock()
+
+	profile, err := createProfileLocked(chatID, title)
+	if err != nil {
+		return NKOProfile{}, err
+	}
+	return profile.clone(), persistNKODataLocked()
+}
+
+// SwitchProfile — сделать профиль активным
+func SwitchProfile(chatID int64, profileID string) (NKOProfile, error) {
+	nkoDataMu.Lock()
+	defer nkoDataMu.Unlock()
+
+	profile := userProfileLocked(chatID, profileID)
+	if profile == nil {
+		return NKOProfile{}, errProfileNotFound
+	}
+	nkoDB.Users[chatID].Active = profile.ID
+	return profile.clone(), persistNKODataLocked()
+}
+
+// DeleteProfile — удалить профиль пользователя
+// Владелец удаляет профиль у всей команды, остальные участники просто выходят из него.
+// Если удаляется активный профиль, активным становится первый из оставшихся.
+func DeleteProfile(chatID int64, profileID string) error {
+	nkoDataMu.Lock()
+	defer nkoDataMu.Unlock()
+
+	profile := userProfileLocked(chatID, profileID)
+	if profile == nil {
+		return errProfileNotFound
+	}
+
+	if profile.Owner != chatID {
+		detachProfileLocked(chatID, profileID)
+		return persistNKODataLocked()
+	}
+	for memberID := range profile.Members {
+		detachProfileLocked(memberID, profileID)
+	}
+	detachProfileLocked(chatID, profileID)
+	delete(nkoDB.Profiles, profileID)
+
+	return persistNKODataLocked()
+}
@@ -0,0 +1,238 @@
+// server.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Режимы получения обновлений (MODE)
+const (
+	modePolling = "polling"
+	modeWebhook = "webhook"
+)
+
+const (
+	defaultHTTPListen   = ":8080"
+	webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+	httpShutdownTimeout = 10 * time.Second
+)
+
+// webhookSecretPattern — допустимые символы secret_token по документации Bot API
+var webhookSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// webhookPathPattern — webhook_path: абсолютный путь без шаблонов ServeMux ({…}, метод, пробелы)
+var webhookPathPattern = regexp.MustCompile(`^/[A-Za-z0-9._~/-]+$`)
+
+// reservedHTTPPaths — служебные маршруты httpMux; webhook_path с ними совпадать не может (ServeMux упадёт при запуске)
+var reservedHTTPPaths = []string{"/healthz", "/metrics", "/loglevel"}
+
+// httpMux — маршруты встроенного HTTP сервера: вебхук Telegram, /healthz и другие служебные endpoints
+var httpMux = http.NewServeMux()
+
+func init() {
+	httpMux.HandleFunc("/healthz", handleHealthz)
+}
+
+// handleHealthz — проверка живости для балансировщика / оркестратора
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok"))
+}
+
+// startHTTPServer — запускает встроенный сервер на addr (HTTPS, если заданы сертификат и ключ)
+// Порт занимается сразу, чтобы ошибка (например, порт занят) остановила запуск бота.
+func startHTTPServer(addr, certFile, keyFile string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s: %w", addr, err)
+	}
+	server := &http.Server{Handler: httpMux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		var err error
+		if certFile != "" {
+			err = server.ServeTLS(listener, certFile, keyFile)
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server stopped", "err", err)
+		}
+	}()
+	slog.Info("HTTP server listening", "addr", listener.Addr().String(), "tls", certFile != "")
+	return server, nil
+}
+
+// startUpdates — запускает получение обновлений в режиме server.mode (polling или webhook)
+// Возвращает канал обновлений и функцию остановки (снимает вебхук и останавливает HTTP сервер).
+func startUpdates(bot *tgbotapi.BotAPI) (tgbotapi.UpdatesChannel, func(), error) {
+	mode := updateMode()
+
+	// В режиме polling HTTP сервер нужен только для служебных endpoints и включается явно
+	listen := config.Server.Listen
+	if listen == "" && mode == modeWebhook {
+		listen = defaultHTTPListen
+	}
+	var server *http.Server
+	if listen != "" {
+		var err error
+		server, err = startHTTPServer(listen, config.Server.TLSCert, config.Server.TLSKey)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	stopServer := func() {
+		if server == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		server.Shutdown(ctx)
+	}
+
+	if mode == modePolling {
+		updates, err := startPolling(bot)
+		if err != nil {
+			stopServer()
+			return nil, nil, err
+		}
+		return updates, func() {
+			bot.StopReceivingUpdates()
+			stopServer()
+		}, nil
+	}
+
+	updates, err := startWebhook(bot)
+	if err != nil {
+		stopServer()
+		return nil, nil, err
+	}
+	return updates, func() {
+		if config.Server.WebhookDeleteOnExit {
+			deleteWebhook(bot)
+		}
+		stopServer()
+	}, nil
+}
+
+// updateMode — режим получения обновлений
+func updateMode() string {
+	if config.Server.Mode == "" {
+		return modePolling
+	}
+	return config.Server.Mode
+}
+
+// confirmPolledUpdates — подтвердить Telegram обработанные обновления при остановке
+// Иначе последняя полученная пачка придёт повторно после перезапуска. Обновления новее
+// lastUpdateID остаются неподтверждёнными и будут доставлены следующему запуску.
+func confirmPolledUpdates(bot *tgbotapi.BotAPI, lastUpdateID int) {
+	if lastUpdateID == 0 {
+		return
+	}
+	u := tgbotapi.NewUpdate(lastUpdateID + 1)
+	u.Limit = 1
+	u.Timeout = 0
+	if _, err := bot.GetUpdates(u); err != nil {
+		slog.Warn("Failed to confirm updates", "last_update_id", lastUpdateID, "err", err)
+	}
+}
+
+// startPolling — long polling; оставшийся с прошлого запуска вебхук снимается, иначе getUpdates не работает
+func startPolling(bot *tgbotapi.BotAPI) (tgbotapi.UpdatesChannel, error) {
+	info, err := bot.GetWebhookInfo()
+	if err != nil {
+		return nil, fmt.Errorf("get webhook info: %w", err)
+	}
+	if info.URL != "" {
+		slog.Warn("Webhook is set, removing it to use long polling")
+		if err := deleteWebhook(bot); err != nil {
+			return nil, err
+		}
+	}
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+	slog.Info("Receiving updates via long polling")
+	return bot.GetUpdatesChan(u), nil
+}
+
+// startWebhook — регистрирует вебхук в Telegram и принимает обновления на секретном пути
+// server.webhook_url — публичный адрес (https://bot.example.com), server.webhook_path — путь (по умолчанию
+// выводится из токена и не угадывается), server.webhook_secret — secret_token (по умолчанию случайный).
+func startWebhook(bot *tgbotapi.BotAPI) (tgbotapi.UpdatesChannel, error) {
+	publicURL := strings.TrimRight(config.Server.WebhookURL, "/")
+	if publicURL == "" {
+		return nil, errors.New("server.webhook_url (WEBHOOK_URL) is required for mode webhook")
+	}
+	path := config.Server.WebhookPath
+	if path == "" {
+		sum := sha256.Sum256([]byte("webhook:" + bot.Token))
+		path = "/telegram/" + hex.EncodeToString(sum[:16])
+	}
+	secret := config.Server.WebhookSecret
+	if secret == "" {
+		b := make([]byte, 32)
+		rand.Read(b)
+		secret = hex.EncodeToString(b)
+	}
+	if !webhookSecretPattern.MatchString(secret) {
+		return nil, errors.New("server.webhook_secret may contain only A-Z, a-z, 0-9, _ and - (up to 256 characters)")
+	}
+
+	updates := make(chan tgbotapi.Update, bot.Buffer)
+	httpMux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(webhookSecretHeader)), []byte(secret)) != 1 {
+			slog.Warn("Webhook request rejected: bad secret token", "remote_addr", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		update, err := bot.HandleUpdate(r)
+		if err != nil {
+			slog.Warn("Bad webhook update", "err", err)
+			http.Error(w, "bad update", http.StatusBadRequest)
+			return
+		}
+		select {
+		case updates <- *update:
+		case <-r.Context().Done():
+			// Telegram повторит доставку, если не получит ответ 200
+		}
+	})
+
+	params := tgbotapi.Params{}
+	params["url"] = publicURL + path
+	params["secret_token"] = secret
+	params["allowed_updates"] = `["message","callback_query"]`
+	if _, err := bot.MakeRequest("setWebhook", params); err != nil {
+		return nil, fmt.Errorf("set webhook: %w", err)
+	}
+	slog.Info("Receiving updates via webhook", "url", publicURL+"/…")
+	return updates, nil
+}
+
+// deleteWebhook — снять вебхук (обновления, накопившиеся в Telegram, сохраняются)
+func deleteWebhook(bot *tgbotapi.BotAPI) error {
+	if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		slog.Error("Failed to delete webhook", "err", err)
+		return fmt.Errorf("delete webhook: %w", err)
+	}
+	return nil
+}
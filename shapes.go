@@ -0,0 +1,201 @@
+// shapes.go
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+const (
+	defaultShapeColor   = "#ffffff" // Заливка фигур по умолчанию
+	defaultLineColor    = "#000000" // Цвет линии по умолчанию
+	defaultLineWidth    = 2.0       // Толщина линии по умолчанию
+	defaultCornerRadius = 16.0      // Радиус скругления rounded_rect по умолчанию
+)
+
+// layerBox — прямоугольник слоя: x, y и размеры width/height (или w/h)
+// Если размер не указан, берётся defaultW / defaultH.
+func layerBox(data map[string]interface{}, defaultW, defaultH float64) (x, y, w, h float64) {
+	x = getFloat(data, "x", 0)
+	y = getFloat(data, "y", 0)
+	w = getFloat(data, "width", getFloat(data, "w", defaultW))
+	h = getFloat(data, "height", getFloat(data, "h", defaultH))
+	return x, y, w, h
+}
+
+// fillPattern — заливка фигуры из color; "none" или "" — без заливки (только обводка)
+func fillPattern(data map[string]interface{}, defaultColor string) gg.Pattern {
+	colorStr := getString(data, "color", defaultColor)
+	if colorStr == "" || colorStr == "none" {
+		return nil
+	}
+	return gg.NewSolidPattern(parseColor(colorStr))
+}
+
+// fillAndStroke — заливает текущий путь и обводит его stroke_color толщиной stroke_width
+func fillAndStroke(dc *gg.Context, data map[string]interface{}, fill gg.Pattern) {
+	if fill != nil {
+		dc.SetFillStyle(fill)
+		dc.FillPreserve()
+	}
+	if width := getFloat(data, "stroke_width", 0); width > 0 {
+		dc.SetStrokeStyle(gg.NewSolidPattern(parseColor(getString(data, "stroke_color", defaultLineColor))))
+		dc.SetLineWidth(width)
+		dc.SetLineJoinRound()
+		dc.StrokePreserve()
+	}
+	dc.ClearPath()
+}
+
+// drawRectangle — рисует прямоугольник
+func drawRectangle(dc *gg.Context, data map[string]interface{}) {
+	x, y, w, h := layerBox(data, 100, 100)
+	dc.DrawRectangle(x, y, w, h)
+	fillAndStroke(dc, data, fillPattern(data, defaultShapeColor))
+}
+
+// drawRoundedRect — прямоугольник со скруглёнными углами радиуса radius
+func drawRoundedRect(dc *gg.Context, data map[string]interface{}) {
+	x, y, w, h := layerBox(data, 100, 100)
+	radius := max(0, min(getFloat(data, "radius", defaultCornerRadius), math.Min(w, h)/2))
+	dc.DrawRoundedRectangle(x, y, w, h, radius)
+	fillAndStroke(dc, data, fillPattern(data, defaultShapeColor))
+}
+
+// drawEllipse — эллипс, вписанный в прямоугольник x, y, width, height (круг — при равных сторонах)
+func drawEllipse(dc *gg.Context, data map[string]interface{}) {
+	x, y, w, h := layerBox(data, 100, 100)
+	dc.DrawEllipse(x+w/2, y+h/2, w/2, h/2)
+	fillAndStroke(dc, data, fillPattern(data, defaultShapeColor))
+}
+
+// drawLine — отрезок от x1, y1 до x2, y2 цвета color толщиной stroke_width
+func drawLine(dc *gg.Context, data map[string]interface{}) error {
+	width := getFloat(data, "stroke_width", defaultLineWidth)
+	if width <= 0 {
+		return fmt.Errorf("stroke_width must be positive")
+	}
+	dc.DrawLine(getFloat(data, "x1", 0), getFloat(data, "y1", 0), getFloat(data, "x2", 0), getFloat(data, "y2", 0))
+	dc.SetStrokeStyle(gg.NewSolidPattern(parseColor(getString(data, "color", defaultLineColor))))
+	dc.SetLineWidth(width)
+	if getString(data, "line_cap", "round") == "butt" {
+		dc.SetLineCapButt()
+	} else {
+		dc.SetLineCapRound()
+	}
+	dc.Stroke()
+	return nil
+}
+
+// drawPolygon — многоугольник по точкам points: [[x, y], …] или [{"x": …, "y": …}, …]
+func drawPolygon(dc *gg.Context, data map[string]interface{}) error {
+	points, err := getPoints(data, "points")
+	if err != nil {
+		return err
+	}
+	if len(points) < 3 {
+		return fmt.Errorf("polygon needs at least 3 points, got %d", len(points))
+	}
+	for _, p := range points {
+		dc.LineTo(p.X, p.Y)
+	}
+	dc.ClosePath()
+	fillAndStroke(dc, data, fillPattern(data, defaultShapeColor))
+	return nil
+}
+
+// drawGradient — градиентная заливка прямоугольника (по умолчанию — всего холста)
+// kind: linear (направление angle в градусах: 0 — слева направо, 90 — сверху вниз) или radial (от центра до radius).
+// Цвета — stops: [{"offset": 0, "color": "#000000"}, …] или colors: ["#000000", …] с равным шагом.
+func drawGradient(dc *gg.Context, data map[string]interface{}) error {
+	x, y, w, h := layerBox(data, float64(dc.Width()), float64(dc.Height()))
+	cx, cy := x+w/2, y+h/2
+
+	var grad gg.Gradient
+	switch kind := getString(data, "kind", "linear"); kind {
+	case "linear":
+		angle := getFloat(data, "angle", 90) * math.Pi / 180
+		dx, dy := math.Cos(angle), math.Sin(angle)
+		half := (math.Abs(w*dx) + math.Abs(h*dy)) / 2 // Градиент проходит прямоугольник из угла в угол
+		grad = gg.NewLinearGradient(cx-dx*half, cy-dy*half, cx+dx*half, cy+dy*half)
+	case "radial":
+		radius := getFloat(data, "radius", math.Min(w, h)/2)
+		grad = gg.NewRadialGradient(cx, cy, 0, cx, cy, radius)
+	default:
+		return fmt.Errorf("unknown gradient kind %q", kind)
+	}
+
+	stops, err := gradientStops(data)
+	if err != nil {
+		return err
+	}
+	for _, stop := range stops {
+		grad.AddColorStop(stop.offset, parseColor(stop.color))
+	}
+
+	dc.DrawRectangle(x, y, w, h)
+	fillAndStroke(dc, data, grad)
+	return nil
+}
+
+// gradientStop — цвет градиента в точке offset (0..1)
+type gradientStop struct {
+	offset float64
+	color  string
+}
+
+// gradientStops — цвета градиента из stops или colors (нужно хотя бы два)
+func gradientStops(data map[string]interface{}) ([]gradientStop, error) {
+	var stops []gradientStop
+	if raw, ok := data["stops"].([]interface{}); ok {
+		for _, item := range raw {
+			stop, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("gradient stop must be an object with offset and color")
+			}
+			stops = append(stops, gradientStop{
+				offset: max(0, min(getFloat(stop, "offset", 0), 1)),
+				color:  getString(stop, "color", defaultLineColor),
+			})
+		}
+	} else if raw, ok := data["colors"].([]interface{}); ok {
+		for i, item := range raw {
+			colorStr, _ := item.(string)
+			offset := 0.0
+			if len(raw) > 1 {
+				offset = float64(i) / float64(len(raw)-1)
+			}
+			stops = append(stops, gradientStop{offset: offset, color: colorStr})
+		}
+	}
+	if len(stops) < 2 {
+		return nil, fmt.Errorf("gradient needs at least 2 colors in stops or colors")
+	}
+	return stops, nil
+}
+
+// getPoints — список точек из map: пары [x, y] или объекты {"x": …, "y": …}
+func getPoints(data map[string]interface{}, key string) ([]gg.Point, error) {
+	raw, ok := data[key].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("no %s in layer data", key)
+	}
+	points := make([]gg.Point, 0, len(raw))
+	for _, item := range raw {
+		switch p := item.(type) {
+		case []interface{}:
+			if len(p) != 2 {
+				return nil, fmt.Errorf("point must be [x, y], got %d numbers", len(p))
+			}
+			xy := map[string]interface{}{"x": p[0], "y": p[1]}
+			points = append(points, gg.Point{X: getFloat(xy, "x", 0), Y: getFloat(xy, "y", 0)})
+		case map[string]interface{}:
+			points = append(points, gg.Point{X: getFloat(p, "x", 0), Y: getFloat(p, "y", 0)})
+		default:
+			return nil, fmt.Errorf("point must be [x, y] or {\"x\": …, \"y\": …}")
+		}
+	}
+	return points, nil
+}
@@ -0,0 +1,71 @@
+// shutdown.go
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// shutdownTimeout — сколько ждать завершения начатых задач при остановке (shutdown_timeout)
+var shutdownTimeout = 30 * time.Second
+
+// shutdown — корректная остановка бота по SIGINT/SIGTERM:
+// 1. перестаём получать обновления, а уже принятые обрабатываем;
+// 2. ждём фоновые задачи до shutdownTimeout, незавершённые прерываем с сообщением о перезапуске;
+// 3. сохраняем состояния и данные НКО.
+func shutdown(bot *tgbotapi.BotAPI, updates tgbotapi.UpdatesChannel, stopUpdates func(), lastUpdateID int) {
+	slog.Info("Shutting down", "timeout", shutdownTimeout)
+	deadline := time.Now().Add(shutdownTimeout)
+
+	// Вебхук при остановке сервера дожидается своих обработчиков, а они — места в канале,
+	// поэтому канал разбираем параллельно с остановкой
+	stopped := make(chan struct{})
+	go func() {
+		stopUpdates()
+		close(stopped)
+	}()
+	handled := 0
+	for draining := true; draining; {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				draining = false
+				break
+			}
+			HandleUpdate(context.Background(), update, bot)
+			lastUpdateID = update.UpdateID
+			handled++
+		case <-stopped:
+			draining = false
+		}
+	}
+	// Что успело попасть в буфер
+	for drained := false; !drained; {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				drained = true
+				break
+			}
+			HandleUpdate(context.Background(), update, bot)
+			lastUpdateID = update.UpdateID
+			handled++
+		default:
+			drained = true
+		}
+	}
+	if updateMode() == modePolling {
+		confirmPolledUpdates(bot, lastUpdateID)
+	}
+	slog.Info("Stopped receiving updates", "handled_during_shutdown", handled)
+
+	stopJobs(time.Until(deadline))
+
+	if err := CloseDB(); err != nil {
+		slog.Error("Failed to save state on shutdown", "err", err)
+	}
+	slog.Info("Shutdown complete")
+}
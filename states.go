@@ -3,31 +3,41 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
 	"sync"
 	"time"
 )
 
 var (
-	userStates = make(map[int64]*UserState)
-	mu         sync.Mutex
-	nkoDataFile = "nko_data.json" // Файл для хранения данных НКО
-	nkoData    = make(map[int64]NKOData) // Кэш данных НКО
-	nkoDataMu  sync.Mutex
+	userStates  StateStore = newMemoryStateStore() // Хранилище состояний (выбирается в InitDB)
+	mu          sync.Mutex
+	nkoDataFile = "nko_data.json"  // Файл для хранения данных НКО
+	nkoDB       = newNKODatabase() // Кэш профилей НКО
+	nkoDataMu   sync.Mutex
+
+	nkoBackupCount    = 5         // Сколько резервных копий nko_data.json хранить
+	nkoBackupInterval = time.Hour // Как часто делать новую копию
+	lastNKOBackup     time.Time   // Когда сделана последняя копия (нулевое — ещё ни разу после запуска)
 )
 
+// nkoDataVersion — текущая версия формата nko_data.json
+const nkoDataVersion = 2
+
 // GetUserState — получить или создать состояние
 func GetUserState(chatID int64) *UserState {
 	mu.Lock()
 	defer mu.Unlock()
 
-	if state, exists := userStates[chatID]; exists {
+	if state, exists := userStates.Get(chatID); exists {
+		// Профиль могли переключить — подтягиваем актуальные данные НКО
+		syncProfile(state)
 		return state
 	}
 
-	// Загружаем сохранённые данные НКО из файла
-	nko := LoadNKOData(chatID)
+	// Загружаем сохранённые данные активного профиля НКО
+	profileID, nko := LoadNKOData(chatID)
 
 	// Создаём новое состояние с загруженными данными НКО
 	state := &UserState{
@@ -35,135 +45,285 @@ func GetUserState(chatID int64) *UserState {
 		State:     "idle",
 		TempData:  make(map[string]string),
 		UpdatedAt: time.Now(),
+		ProfileID: profileID,
 		NKO:       nko,
 	}
-	userStates[chatID] = state
+	if err := userStates.Put(state); err != nil {
+		slog.Error("Failed to store state", "chat_id", chatID, "err", err)
+	}
+	trackStateLocked(state)
 	return state
 }
 
-// SaveUserState — сохраняем состояние и данные НКО
+// SaveUserState — сохраняем состояние диалога
+// Данные НКО в состоянии — только копия профиля: профиль меняет SaveNKOData
+// (мастер данных НКО), иначе устаревшая копия одного участника затирала бы правки команды.
 func SaveUserState(state *UserState) {
 	mu.Lock()
 	defer mu.Unlock()
 	state.UpdatedAt = time.Now()
-	userStates[state.ChatID] = state
-	
-	// Сохраняем данные НКО в файл, если они заполнены
-	if state.NKO.Name != "" || state.NKO.Description != "" {
-		SaveNKOData(state.ChatID, state.NKO)
+	if err := userStates.Put(state); err != nil {
+		slog.Error("Failed to store state", "chat_id", state.ChatID, "err", err)
 	}
+	trackStateLocked(state)
 }
 
-// ResetUserState — сброс состояния, но сохраняем данные НКО
+// ResetUserState — сброс состояния; данные НКО подтягиваются из активного профиля
 func ResetUserState(chatID int64) {
 	mu.Lock()
 	defer mu.Unlock()
-	
-	// Сохраняем данные НКО перед сбросом
-	if state, exists := userStates[chatID]; exists {
-		if state.NKO.Name != "" || state.NKO.Description != "" {
-			SaveNKOData(chatID, state.NKO)
-		}
-	}
-	
-	// Сбрасываем только состояние и временные данные, НКО данные сохраняем
-	if state, exists := userStates[chatID]; exists {
+
+	state, exists := userStates.Get(chatID)
+	if exists {
+		// Сбрасываем только состояние и временные данные, копию данных НКО обновляем
+		syncProfile(state)
 		state.State = "idle"
 		state.TempData = make(map[string]string)
 		state.UpdatedAt = time.Now()
 	} else {
 		// Если состояния нет, создаём новое с сохранёнными данными НКО
-		nko := LoadNKOData(chatID)
-		userStates[chatID] = &UserState{
+		profileID, nko := LoadNKOData(chatID)
+		state = &UserState{
 			ChatID:    chatID,
 			State:     "idle",
 			TempData:  make(map[string]string),
 			UpdatedAt: time.Now(),
+			ProfileID: profileID,
 			NKO:       nko,
 		}
 	}
+	if err := userStates.Put(state); err != nil {
+		slog.Error("Failed to store state", "chat_id", chatID, "err", err)
+	}
+	trackStateLocked(state)
 }
 
-// SaveNKOData — сохранить данные НКО в файл
-func SaveNKOData(chatID int64, nko NKOData) {
+// newNKODatabase — пустая база профилей
+func newNKODatabase() *NKODatabase {
+	return &NKODatabase{
+		Version:  nkoDataVersion,
+		Profiles: make(map[string]*NKOProfile),
+		Users:    make(map[int64]*UserProfiles),
+	}
+}
+
+// SaveNKOData — сохранить данные НКО в профиль и записать файл
+// Если профиль не указан (или не принадлежит пользователю), создаётся профиль по умолчанию.
+// Возвращает ID профиля, в который сохранены данные.
+func SaveNKOData(chatID int64, profileID string, nko NKOData) (string, error) {
 	nkoDataMu.Lock()
 	defer nkoDataMu.Unlock()
-	
-	// Обновляем кэш
-	nkoData[chatID] = nko
-	
-	// Загружаем существующие данные
-	allData := make(map[int64]NKOData)
-	if data, err := os.ReadFile(nkoDataFile); err == nil {
-		json.Unmarshal(data, &allData)
-	}
-	
-	// Обновляем данные для этого пользователя
-	allData[chatID] = nko
-	
-	// Сохраняем в файл
-	if data, err := json.MarshalIndent(allData, "", "  "); err == nil {
-		os.WriteFile(nkoDataFile, data, 0644)
-	} else {
-		log.Printf("Ошибка сохранения данных НКО: %v", err)
+
+	profile := userProfileLocked(chatID, profileID)
+	if profile == nil {
+		var err error
+		profile, err = createProfileLocked(chatID, defaultProfileTitle(nko))
+		if err != nil {
+			return "", err
+		}
+	} else if profile.NKO == nko {
+		// Ничего не изменилось — не переписываем файл и не плодим резервные копии
+		return profile.ID, nil
 	}
+	profile.NKO = nko
+
+	return profile.ID, persistNKODataLocked()
 }
 
-// LoadNKOData — загрузить данные НКО из файла
-func LoadNKOData(chatID int64) NKOData {
+// LoadNKOData — загрузить данные активного профиля НКО из кэша
+// Возвращает ID активного профиля (пустой, если профилей нет) и его данные
+func LoadNKOData(chatID int64) (string, NKOData) {
 	nkoDataMu.Lock()
 	defer nkoDataMu.Unlock()
-	
-	// Проверяем кэш
-	if nko, exists := nkoData[chatID]; exists {
-		return nko
-	}
-	
-	// Загружаем из файла
-	if data, err := os.ReadFile(nkoDataFile); err == nil {
-		allData := make(map[int64]NKOData)
-		if err := json.Unmarshal(data, &allData); err == nil {
-			// Обновляем кэш
-			for k, v := range allData {
-				nkoData[k] = v
+
+	// Кэш заполняется целиком при старте в InitDB
+	profile := activeProfileLocked(chatID)
+	if profile == nil {
+		return "", NKOData{}
+	}
+	return profile.ID, profile.NKO
+}
+
+// persistNKODataLocked — записать весь кэш в nko_data.json (вызывать под nkoDataMu)
+// Файл записывается атомарно (temp + rename). Резервная копия делается перед первой записью
+// после запуска и затем не чаще раза в backup_interval: если копировать при каждой записи,
+// обычная работа за секунды вытесняет все копии, и восстанавливать становится не из чего.
+func persistNKODataLocked() error {
+	data, err := json.MarshalIndent(nkoDB, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode NKO data: %w", err)
+	}
+	if lastNKOBackup.IsZero() || time.Since(lastNKOBackup) >= nkoBackupInterval {
+		if err := rotateBackups(nkoDataFile, nkoBackupCount); err != nil {
+			slog.Warn("Failed to back up NKO data", "file", nkoDataFile, "err", err)
+		} else {
+			lastNKOBackup = time.Now()
+		}
+	}
+	if err := writeFileAtomic(nkoDataFile, data, 0644); err != nil {
+		return fmt.Errorf("write %s: %w", nkoDataFile, err)
+	}
+	return nil
+}
+
+// readNKODataFile — прочитать и разобрать файл с данными НКО
+// Файлы старого формата (map chat ID → NKOData) переводятся в профили на лету,
+// в этом случае migrated == true.
+func readNKODataFile(path string) (db *NKODatabase, migrated bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, false, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if _, ok := probe["Version"]; ok {
+		db = newNKODatabase()
+		if err := json.Unmarshal(data, db); err != nil {
+			return nil, false, fmt.Errorf("parse %s: %w", path, err)
+		}
+		if db.Profiles == nil {
+			db.Profiles = make(map[string]*NKOProfile)
+		}
+		if db.Users == nil {
+			db.Users = make(map[int64]*UserProfiles)
+		}
+		normalizeWorkspaces(db)
+		return db, false, nil
+	}
+
+	// Старый формат: одна НКО на пользователя
+	legacy := make(map[int64]NKOData)
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return nil, false, fmt.Errorf("parse %s: %w", path, err)
+	}
+	db = newNKODatabase()
+	for chatID, nko := range legacy {
+		profile := &NKOProfile{
+			ID:    newProfileID(),
+			Title: defaultProfileTitle(nko),
+			NKO:   nko,
+		}
+		db.Profiles[profile.ID] = profile
+		db.Users[chatID] = &UserProfiles{Active: profile.ID, Profiles: []string{profile.ID}}
+	}
+	normalizeWorkspaces(db)
+	return db, len(legacy) > 0, nil
+}
+
+// loadNKODataWithRecovery — загрузка данных НКО с восстановлением из резервной копии
+// Если основной файл повреждён (или пропал при наличии копий), берём самую свежую
+// читаемую копию, сохраняем битый файл рядом для разбора и восстанавливаем основной.
+func loadNKODataWithRecovery() (*NKODatabase, bool, error) {
+	removeStaleTempFiles(nkoDataFile)
+	db, migrated, err := readNKODataFile(nkoDataFile)
+	if err == nil {
+		return db, migrated, nil
+	}
+
+	missing := os.IsNotExist(err)
+	if missing {
+		if !hasBackups(nkoDataFile, nkoBackupCount) {
+			// Первый запуск — файла и копий ещё нет
+			db = newNKODatabase()
+			data, _ := json.MarshalIndent(db, "", "  ")
+			if err := writeFileAtomic(nkoDataFile, data, 0644); err != nil {
+				return nil, false, fmt.Errorf("create %s: %w", nkoDataFile, err)
 			}
-			// Возвращаем данные для этого пользователя
-			if nko, exists := allData[chatID]; exists {
-				return nko
+			slog.Info("Создан файл для хранения данных НКО", "file", nkoDataFile)
+			return db, false, nil
+		}
+	}
+
+	slog.Error("!!! Файл данных НКО не читается. Пытаюсь восстановить из резервной копии", "file", nkoDataFile, "err", err)
+
+	for n := 1; n <= nkoBackupCount; n++ {
+		path := backupPath(nkoDataFile, n)
+		backup, backupMigrated, berr := readNKODataFile(path)
+		if berr != nil {
+			if !os.IsNotExist(berr) {
+				slog.Error("Резервная копия тоже повреждена", "file", path, "err", berr)
 			}
+			continue
 		}
+
+		if !missing {
+			corruptPath := fmt.Sprintf("%s.corrupt-%s", nkoDataFile, time.Now().Format("20060102-150405"))
+			if rerr := os.Rename(nkoDataFile, corruptPath); rerr != nil {
+				return nil, false, fmt.Errorf("preserve corrupt %s: %w", nkoDataFile, rerr)
+			}
+			slog.Error("Повреждённый файл сохранён", "file", corruptPath)
+		}
+		data, merr := json.MarshalIndent(backup, "", "  ")
+		if merr != nil {
+			return nil, false, fmt.Errorf("encode recovered NKO data: %w", merr)
+		}
+		if werr := writeFileAtomic(nkoDataFile, data, 0644); werr != nil {
+			return nil, false, fmt.Errorf("restore %s from %s: %w", nkoDataFile, path, werr)
+		}
+		slog.Error("!!! Данные НКО восстановлены из резервной копии. Изменения после этой копии потеряны", "file", path, "profiles", len(backup.Profiles))
+		return backup, backupMigrated, nil
 	}
-	
-	// Возвращаем пустые данные, если ничего не найдено
-	return NKOData{
-		Name:        "",
-		Description: "",
-		Activities:  "",
-		Style:       "",
+
+	return nil, false, fmt.Errorf("%s is unreadable and no valid backup found: %w", nkoDataFile, err)
+}
+
+// hasBackups — есть ли у файла хотя бы одна резервная копия из keep
+func hasBackups(path string, keep int) bool {
+	for n := 1; n <= keep; n++ {
+		if _, err := os.Stat(backupPath(path, n)); err == nil {
+			return true
+		}
 	}
+	return false
 }
 
-// InitDB — инициализация хранилища
-func InitDB() {
-	log.Println("In-memory state storage initialized (no SQLite)")
-	
-	// Создаём файл для данных НКО, если его нет
-	if _, err := os.Stat(nkoDataFile); os.IsNotExist(err) {
-		// Создаём пустой файл
-		os.WriteFile(nkoDataFile, []byte("{}"), 0644)
-		log.Printf("Создан файл для хранения данных НКО: %s", nkoDataFile)
-	} else {
-		// Загружаем существующие данные в кэш
-		if data, err := os.ReadFile(nkoDataFile); err == nil {
-			allData := make(map[int64]NKOData)
-			if err := json.Unmarshal(data, &allData); err == nil {
-				nkoDataMu.Lock()
-				for k, v := range allData {
-					nkoData[k] = v
-				}
-				nkoDataMu.Unlock()
-				log.Printf("Загружены данные НКО для %d пользователей", len(allData))
-			}
+// InitDB — инициализация хранилища (настройки storage)
+func InitDB(cfg StorageConfig) error {
+	nkoDataFile = cfg.NKODataPath
+	nkoBackupCount = cfg.Backups
+	nkoBackupInterval = cfg.BackupInterval
+
+	store, err := newStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	userStates = store
+	resetStateCountsLocked()
+	mu.Unlock()
+	slog.Info("State storage initialized", "store", fmt.Sprintf("%T", store))
+
+	// Загружаем данные НКО в кэш (с восстановлением из резервной копии при повреждении)
+	db, migrated, err := loadNKODataWithRecovery()
+	if err != nil {
+		return err
+	}
+	nkoDataMu.Lock()
+	defer nkoDataMu.Unlock()
+	nkoDB = db
+	if migrated {
+		// Переводим файл в новый формат; старая версия остаётся в резервной копии
+		if err := persistNKODataLocked(); err != nil {
+			return fmt.Errorf("migrate %s: %w", nkoDataFile, err)
 		}
+		slog.Info("Данные НКО переведены в формат профилей", "version", nkoDataVersion)
 	}
+	slog.Info("Загружены профили НКО", "profiles", len(db.Profiles), "users", len(db.Users))
+	return nil
+}
+
+// CloseDB — сохранение данных при остановке бота
+// nko_data.json записывается атомарно при каждом изменении, поэтому достаточно дождаться
+// записи, которая сейчас идёт; состояния диалогов сбрасываются на диск (при state_store: file).
+func CloseDB() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	nkoDataMu.Lock()
+	nkoDataMu.Unlock()
+
+	return userStates.Close()
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useTestNKOData — пустые данные НКО и состояния в памяти; nko_data.json — во временном каталоге
+func useTestNKOData(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	oldFile, oldDB, oldStates, oldBackup := nkoDataFile, nkoDB, userStates, lastNKOBackup
+	oldCount, oldInterval := nkoBackupCount, nkoBackupInterval
+	t.Cleanup(func() {
+		nkoDataFile, nkoDB, userStates, lastNKOBackup = oldFile, oldDB, oldStates, oldBackup
+		nkoBackupCount, nkoBackupInterval = oldCount, oldInterval
+	})
+	nkoDataFile = filepath.Join(dir, "nko_data.json")
+	nkoDB = newNKODatabase()
+	userStates = newMemoryStateStore()
+	lastNKOBackup = time.Time{}
+	return dir
+}
+
+func TestStateSaveKeepsSharedProfile(t *testing.T) {
+	useTestNKOData(t)
+	const owner, editor = int64(1), int64(2)
+	profileID, err := SaveNKOData(owner, "", NKOData{Name: "Старое название"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nkoDB.Profiles[profileID].Members[editor] = WorkspaceMember{Role: roleEditor, JoinedAt: time.Now()}
+	nkoDB.Users[editor] = &UserProfiles{Active: profileID, Profiles: []string{profileID}}
+
+	// У редактора в состоянии — копия профиля, которая устаревает после правки владельца
+	stale := GetUserState(editor)
+	if _, err := SaveNKOData(owner, profileID, NKOData{Name: "Новое название"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stale.State = "waiting_text"
+	SaveUserState(stale)
+	if got := nkoDB.Profiles[profileID].NKO.Name; got != "Новое название" {
+		t.Fatalf("after SaveUserState profile name = %q, want the owner's edit", got)
+	}
+	ResetUserState(editor)
+	if got := nkoDB.Profiles[profileID].NKO.Name; got != "Новое название" {
+		t.Fatalf("after ResetUserState profile name = %q, want the owner's edit", got)
+	}
+	if got := GetUserState(editor).NKO.Name; got != "Новое название" {
+		t.Errorf("state after reset has NKO name %q, want the profile's", got)
+	}
+}
+
+// nkoFixture — nko_data.json версии 2 с одним профилем title у пользователя 1
+func nkoFixture(t *testing.T, title string) []byte {
+	t.Helper()
+	db := newNKODatabase()
+	db.Profiles["p1"] = &NKOProfile{ID: "p1", Title: title, Owner: 1, NKO: NKOData{Name: title}}
+	db.Users[1] = &UserProfiles{Active: "p1", Profiles: []string{"p1"}}
+	data, err := json.Marshal(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestLoadNKODataWithRecovery(t *testing.T) {
+	tests := []struct {
+		name        string
+		main        string   // Основной файл: "good", "corrupt" или "" (нет)
+		backups     []string // .bak.1, .bak.2, …: "good", "corrupt" или "" (нет)
+		staleTemp   bool     // Рядом остался временный файл прерванной записи
+		wantTitle   string   // Откуда взяты данные ("" — пустая база)
+		wantCorrupt bool     // Битый основной файл сохранён как .corrupt-<время>
+		wantErr     bool
+	}{
+		{"good file", "good", []string{"good"}, false, "main", false, false},
+		{"corrupt file, good backup", "corrupt", []string{"good"}, false, "bak.1", true, false},
+		{"corrupt file and newest backup", "corrupt", []string{"corrupt", "good"}, false, "bak.2", true, false},
+		{"missing file, backups present", "", []string{"", "good"}, false, "bak.2", false, false},
+		{"missing file, newest backup present", "", []string{"good", "good"}, false, "bak.1", false, false},
+		{"first start", "", nil, false, "", false, false},
+		{"no valid backup", "corrupt", []string{"corrupt"}, false, "", false, true},
+		{"temp file after interrupted write", "good", nil, true, "main", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := useTestNKOData(t)
+			write := func(path, kind, title string) {
+				switch kind {
+				case "good":
+					os.WriteFile(path, nkoFixture(t, title), 0644)
+				case "corrupt":
+					os.WriteFile(path, []byte(`{"Version":2,"Profiles":{`), 0644)
+				}
+			}
+			write(nkoDataFile, tt.main, "main")
+			for i, kind := range tt.backups {
+				write(backupPath(nkoDataFile, i+1), kind, fmt.Sprintf("bak.%d", i+1))
+			}
+			tempFile := filepath.Join(dir, ".nko_data.json.tmp-1")
+			if tt.staleTemp {
+				os.WriteFile(tempFile, []byte(`{"Version":2,"Pro`), 0644)
+			}
+
+			db, _, err := loadNKODataWithRecovery()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadNKODataWithRecovery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			corrupt, _ := filepath.Glob(filepath.Join(dir, "nko_data.json.corrupt-*"))
+			if (len(corrupt) > 0) != tt.wantCorrupt {
+				t.Errorf("corrupt copies = %v, want corrupt copy %v", corrupt, tt.wantCorrupt)
+			}
+			if _, err := os.Stat(tempFile); err == nil {
+				t.Error("stale temp file is not removed")
+			}
+			if tt.wantErr {
+				return
+			}
+
+			title := ""
+			if profile, ok := db.Profiles["p1"]; ok {
+				title = profile.Title
+			}
+			if title != tt.wantTitle {
+				t.Errorf("loaded profile title = %q, want %q", title, tt.wantTitle)
+			}
+			// Основной файл восстановлен и читается
+			restored, migrated, err := readNKODataFile(nkoDataFile)
+			if err != nil || migrated {
+				t.Fatalf("main file after load: migrated %v, error %v", migrated, err)
+			}
+			if len(restored.Profiles) != len(db.Profiles) {
+				t.Errorf("main file has %d profiles, want %d", len(restored.Profiles), len(db.Profiles))
+			}
+			if len(corrupt) > 0 {
+				if data, _ := os.ReadFile(corrupt[0]); string(data) != `{"Version":2,"Profiles":{` {
+					t.Errorf("corrupt copy = %q, want the original corrupt file", data)
+				}
+			}
+		})
+	}
+}
+
+func TestLegacyNKODataMigration(t *testing.T) {
+	dir := useTestNKOData(t)
+	legacy, err := os.ReadFile(filepath.Join("testdata", "nko_data_legacy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := StorageConfig{NKODataPath: filepath.Join(dir, "nko_data.json"), Backups: 5, BackupInterval: time.Hour}
+	os.WriteFile(cfg.NKODataPath, legacy, 0644)
+
+	if err := InitDB(cfg); err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	want := map[int64]struct {
+		title string
+		nko   NKOData
+	}{
+		123456789: {"Добрые руки", NKOData{Name: "Добрые руки", Description: "Помогаем пожилым людям", Activities: "волонтёрство, сбор вещей", Style: "дружелюбный"}},
+		987654321: {"Основной", NKOData{Description: "Приют для животных"}},
+	}
+	if nkoDB.Version != nkoDataVersion || len(nkoDB.Users) != len(want) || len(nkoDB.Profiles) != len(want) {
+		t.Fatalf("migrated: version %d, %d users, %d profiles", nkoDB.Version, len(nkoDB.Users), len(nkoDB.Profiles))
+	}
+	for chatID, w := range want {
+		user := nkoDB.Users[chatID]
+		if user == nil || len(user.Profiles) != 1 || user.Active != user.Profiles[0] {
+			t.Fatalf("user %d: %+v, want one active profile", chatID, user)
+		}
+		profile := nkoDB.Profiles[user.Active]
+		if profile == nil || profile.ID != user.Active || profile.Title != w.title || profile.NKO != w.nko {
+			t.Fatalf("user %d profile = %+v, want %q with %+v", chatID, profile, w.title, w.nko)
+		}
+		// Профиль становится рабочим пространством, где пользователь — владелец
+		if profile.Owner != chatID || len(profile.Members) != 1 || profile.Members[chatID].Role != roleOwner {
+			t.Errorf("user %d workspace: owner %d, members %+v", chatID, profile.Owner, profile.Members)
+		}
+	}
+
+	// Файл переписан в новом формате, старая версия — в резервной копии
+	migratedFile, err := os.ReadFile(cfg.NKODataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, migrated, err := readNKODataFile(cfg.NKODataPath); err != nil || migrated {
+		t.Fatalf("file after migration: migrated %v, error %v", migrated, err)
+	}
+	if backup, _ := os.ReadFile(backupPath(cfg.NKODataPath, 1)); !bytes.Equal(backup, legacy) {
+		t.Errorf(".bak.1 = %s, want the legacy file", backup)
+	}
+
+	// Повторная загрузка ничего не меняет
+	profiles := nkoDB.Profiles
+	if err := InitDB(cfg); err != nil {
+		t.Fatalf("second InitDB() error = %v", err)
+	}
+	if data, _ := os.ReadFile(cfg.NKODataPath); !bytes.Equal(data, migratedFile) {
+		t.Error("second load rewrote the file")
+	}
+	if _, err := os.Stat(backupPath(cfg.NKODataPath, 2)); err == nil {
+		t.Error("second load made another backup")
+	}
+	for id, profile := range profiles {
+		if got := nkoDB.Profiles[id]; got == nil || got.NKO != profile.NKO || got.Owner != profile.Owner {
+			t.Errorf("profile %s after second load = %+v, want %+v", id, got, profile)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// store.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// StateStore — хранилище состояний пользователей (GetUserState/SaveUserState работают через него)
+type StateStore interface {
+	// Get — возвращает состояние пользователя, если оно есть в хранилище
+	Get(chatID int64) (*UserState, bool)
+	// Put — сохраняет состояние пользователя
+	Put(state *UserState) error
+	// Delete — удаляет состояние пользователя
+	Delete(chatID int64) error
+	// All — все состояния в хранилище (для периодической чистки)
+	All() []*UserState
+	// Close — сбрасывает данные на диск и закрывает хранилище
+	Close() error
+}
+
+// memoryStateStore — хранилище в памяти (данные теряются при перезапуске)
+type memoryStateStore struct {
+	states map[int64]*UserState
+}
+
+func newMemoryStateStore() *memoryStateStore {
+	return &memoryStateStore{states: make(map[int64]*UserState)}
+}
+
+func (s *memoryStateStore) Get(chatID int64) (*UserState, bool) {
+	state, ok := s.states[chatID]
+	return state, ok
+}
+
+func (s *memoryStateStore) Put(state *UserState) error {
+	s.states[state.ChatID] = state
+	return nil
+}
+
+func (s *memoryStateStore) Delete(chatID int64) error {
+	delete(s.states, chatID)
+	return nil
+}
+
+func (s *memoryStateStore) All() []*UserState {
+	states := make([]*UserState, 0, len(s.states))
+	for _, state := range s.states {
+		states = append(states, state)
+	}
+	return states
+}
+
+func (s *memoryStateStore) Close() error {
+	return nil
+}
+
+// fileStateStore — хранилище в одном JSON-файле на диске (ключ — chat ID)
+// Все состояния держим в памяти, а на каждое изменение переписываем файл целиком
+type fileStateStore struct {
+	path   string
+	states map[int64]*UserState
+	mu     sync.Mutex // защищает запись файла
+}
+
+// newFileStateStore — открывает файловое хранилище и загружает сохранённые состояния
+func newFileStateStore(path string) (*fileStateStore, error) {
+	s := &fileStateStore{
+		path:   path,
+		states: make(map[int64]*UserState),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state store %s: %w", path, err)
+	}
+	if len(data) == 0 {
+		return s, nil
+	}
+
+	stored := make(map[int64]*UserState)
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("parse state store %s: %w", path, err)
+	}
+	for chatID, state := range stored {
+		if state == nil {
+			continue
+		}
+		if state.TempData == nil {
+			state.TempData = make(map[string]string)
+		}
+		state.ChatID = chatID
+		s.states[chatID] = state
+	}
+	return s, nil
+}
+
+func (s *fileStateStore) Get(chatID int64) (*UserState, bool) {
+	state, ok := s.states[chatID]
+	return state, ok
+}
+
+func (s *fileStateStore) Put(state *UserState) error {
+	s.states[state.ChatID] = state
+	return s.flush()
+}
+
+func (s *fileStateStore) Delete(chatID int64) error {
+	if _, ok := s.states[chatID]; !ok {
+		return nil
+	}
+	delete(s.states, chatID)
+	return s.flush()
+}
+
+func (s *fileStateStore) All() []*UserState {
+	states := make([]*UserState, 0, len(s.states))
+	for _, state := range s.states {
+		states = append(states, state)
+	}
+	return states
+}
+
+func (s *fileStateStore) Close() error {
+	return s.flush()
+}
+
+// flush — записывает все состояния в файл
+func (s *fileStateStore) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(s.states, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode state store: %w", err)
+	}
+	if err := writeFileAtomic(s.path, data, 0644); err != nil {
+		return fmt.Errorf("write state store %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// newStateStore — выбирает хранилище по настройкам storage.state_store (memory или file)
+func newStateStore(cfg StorageConfig) (StateStore, error) {
+	switch cfg.StateStore {
+	case "", "memory":
+		return newMemoryStateStore(), nil
+	case "file":
+		return newFileStateStore(cfg.StatePath)
+	default:
+		return nil, fmt.Errorf("unknown state store %q (expected memory or file)", cfg.StateStore)
+	}
+}
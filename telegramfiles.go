@@ -0,0 +1,72 @@
+// telegramfiles.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	maxTelegramFileSize = 10 << 20 // Больше не скачиваем (Bot API отдаёт файлы до 20 МБ)
+	telegramFileTimeout = 30 * time.Second
+)
+
+var errFileTooLarge = errors.New("файл слишком большой")
+
+// telegramFileClient — клиент для скачивания файлов из Telegram
+var telegramFileClient = &http.Client{}
+
+// downloadTelegramFile — скачивает файл по file_id
+// Ссылка на файл содержит токен бота, поэтому она не покидает процесс: не логируется,
+// не попадает в ошибки и не передаётся в AI агента — наружу уходят только байты.
+func downloadTelegramFile(ctx context.Context, bot *tgbotapi.BotAPI, fileID string) ([]byte, error) {
+	file, err := bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		return nil, fmt.Errorf("get file %s: %w", fileID, withoutURL(err))
+	}
+	if file.FileSize > maxTelegramFileSize {
+		return nil, errFileTooLarge
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, telegramFileTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, file.Link(bot.Token), nil)
+	if err != nil {
+		return nil, fmt.Errorf("download file %s: bad request", fileID)
+	}
+	resp, err := telegramFileClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download file %s: %w", fileID, withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download file %s: status %d", fileID, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxTelegramFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("download file %s: %w", fileID, err)
+	}
+	if len(data) > maxTelegramFileSize {
+		return nil, errFileTooLarge
+	}
+	return data, nil
+}
+
+// withoutURL — причина ошибки HTTP-запроса без адреса
+// *url.Error содержит ссылку с токеном бота (и запросы Bot API, и ссылки на файлы), поэтому от неё остаётся только Err.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
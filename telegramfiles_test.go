@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const testBotToken = "123456789:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw1"
+
+// roundTripFunc — транспорт HTTP из функции (ответы Telegram без сети)
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// testBot — бот, запросы которого к Bot API обрабатывает transport
+func testBot(transport roundTripFunc) *tgbotapi.BotAPI {
+	bot := &tgbotapi.BotAPI{Token: testBotToken, Client: &http.Client{Transport: transport}}
+	bot.SetAPIEndpoint(tgbotapi.APIEndpoint)
+	return bot
+}
+
+// jsonResponse — ответ 200 с телом body
+func jsonResponse(body string) *http.Response {
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+// getFileOK — Bot API, который находит любой файл
+func getFileOK(*http.Request) (*http.Response, error) {
+	return jsonResponse(`{"ok":true,"result":{"file_id":"f1","file_path":"photos/1.jpg","file_size":3}}`), nil
+}
+
+// failTransport — транспорт, у которого любой запрос обрывается
+func failTransport(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection reset by peer")
+}
+
+func TestDownloadTelegramFileHidesToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		api      roundTripFunc // Запросы к Bot API (getFile)
+		download roundTripFunc // Скачивание файла по ссылке
+		wantErr  string
+	}{
+		{"get file fails", failTransport, nil, "get file f1: connection reset by peer"},
+		{"get file api error", func(*http.Request) (*http.Response, error) {
+			return jsonResponse(`{"ok":false,"error_code":400,"description":"Bad Request: invalid file_id"}`), nil
+		}, nil, "get file f1: Bad Request: invalid file_id"},
+		{"download fails", getFileOK, failTransport, "download file f1: connection reset by peer"},
+		{"download status", getFileOK, func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusNotFound, Body: http.NoBody}, nil
+		}, "download file f1: status 404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(client *http.Client) { telegramFileClient = client }(telegramFileClient)
+			telegramFileClient = &http.Client{Transport: tt.download}
+
+			_, err := downloadTelegramFile(context.Background(), testBot(tt.api), "f1")
+			if err == nil {
+				t.Fatal("downloadTelegramFile() error = nil")
+			}
+			if strings.Contains(err.Error(), testBotToken) {
+				t.Fatalf("downloadTelegramFile() error leaks the bot token: %v", err)
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("downloadTelegramFile() error = %q, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("success", func(t *testing.T) {
+		defer func(client *http.Client) { telegramFileClient = client }(telegramFileClient)
+		telegramFileClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return jsonResponse("abc"), nil
+		})}
+		data, err := downloadTelegramFile(context.Background(), testBot(getFileOK), "f1")
+		if err != nil || string(data) != "abc" {
+			t.Errorf("downloadTelegramFile() = %q, %v", data, err)
+		}
+	})
+}
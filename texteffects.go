@@ -0,0 +1,251 @@
+// texteffects.go
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+const (
+	defaultTextPadding = 16.0        // Отступ подложки background от текста
+	defaultShadowColor = "#00000080" // Цвет тени по умолчанию
+	defaultShadowBlur  = 6.0         // Размытие тени по умолчанию
+	defaultShadowShift = 4.0         // Смещение тени по умолчанию (вправо и вниз)
+	maxTextStrokeWidth = 100.0       // Предел stroke_width текста
+	maxShadowBlur      = 100.0       // Предел shadow.blur
+)
+
+// textShadow — тень текста: смещение, размытие и цвет
+type textShadow struct {
+	offsetX, offsetY float64 // Не больше maxCanvasSide по модулю
+	blur             float64
+	color            string
+}
+
+// layerTextShadow — shadow: {"offset_x": …, "offset_y": …, "blur": …, "color": …} или shadow: true (тень по умолчанию)
+func layerTextShadow(data map[string]interface{}) (textShadow, bool) {
+	shadow := textShadow{offsetX: defaultShadowShift, offsetY: defaultShadowShift, blur: defaultShadowBlur, color: defaultShadowColor}
+	switch v := data["shadow"].(type) {
+	case bool:
+		return shadow, v
+	case map[string]interface{}:
+		shadow.offsetX = max(-maxCanvasSide, min(getFloat(v, "offset_x", shadow.offsetX), maxCanvasSide))
+		shadow.offsetY = max(-maxCanvasSide, min(getFloat(v, "offset_y", shadow.offsetY), maxCanvasSide))
+		shadow.blur = max(0, min(getFloat(v, "blur", shadow.blur), maxShadowBlur))
+		shadow.color = getString(v, "color", shadow.color)
+		return shadow, true
+	default:
+		return shadow, false
+	}
+}
+
+// drawTextBackground — подложка под текстом: background: "#RRGGBBAA" или {"color": …, "padding": …, "radius": …}
+// Прямоугольник охватывает строки с отступом padding, radius скругляет углы (вплоть до «пилюли»).
+func drawTextBackground(dc *gg.Context, layout *textLayout, data map[string]interface{}) {
+	var background map[string]interface{}
+	switch v := data["background"].(type) {
+	case string:
+		background = map[string]interface{}{"color": v}
+	case map[string]interface{}:
+		background = v
+	default:
+		return
+	}
+	colorHex := getString(background, "color", "")
+	if colorHex == "" || len(layout.lines) == 0 {
+		return
+	}
+
+	left, top, right, bottom := layout.bounds()
+	padding := max(0, getFloat(background, "padding", defaultTextPadding))
+	paddingX := max(0, getFloat(background, "padding_x", padding))
+	paddingY := max(0, getFloat(background, "padding_y", padding))
+	x, y := left-paddingX, top-paddingY
+	w, h := right-left+2*paddingX, bottom-top+2*paddingY
+	radius := max(0, min(getFloat(background, "radius", 0), math.Min(w, h)/2))
+
+	dc.DrawRoundedRectangle(x, y, w, h, radius)
+	dc.SetColor(parseColor(colorHex))
+	dc.Fill()
+}
+
+// drawTextEffects — тень и обводка букв (рисуются до самого текста)
+// Буквы сначала растеризуются в маску; обводка — маска, расширенная на stroke_width,
+// тень — размытая маска (с обводкой, если она есть), сдвинутая на offset_x, offset_y.
+func drawTextEffects(dc *gg.Context, layout *textLayout, data map[string]interface{}) error {
+	strokeWidth := max(0, min(getFloat(data, "stroke_width", 0), maxTextStrokeWidth))
+	shadow, hasShadow := layerTextShadow(data)
+	if (strokeWidth == 0 && !hasShadow) || len(layout.lines) == 0 {
+		return nil
+	}
+	dst, ok := dc.Image().(draw.Image)
+	if !ok {
+		return fmt.Errorf("canvas image is not drawable")
+	}
+
+	// Маска — только вокруг текста: с запасом на обводку и размытие, но не дальше холста
+	pad := strokeWidth + 2
+	if hasShadow {
+		pad += shadow.blur * 1.5
+	}
+	left, top, right, bottom := layout.bounds()
+	area := image.Rect(int(math.Floor(left-pad)), int(math.Floor(top-pad)), int(math.Ceil(right+pad)), int(math.Ceil(bottom+pad)))
+	offset := image.Pt(int(math.Round(shadow.offsetX)), int(math.Round(shadow.offsetY)))
+	visible := dst.Bounds().Inset(-int(math.Ceil(pad)))
+	if hasShadow {
+		visible = visible.Union(visible.Sub(offset))
+	}
+	area = area.Intersect(visible)
+	if area.Empty() {
+		return nil
+	}
+
+	mask := textMask(layout, area)
+	if strokeWidth > 0 {
+		mask = dilateMask(mask, strokeWidth)
+	}
+	if hasShadow {
+		blurred := blurMask(mask, int(math.Round(shadow.blur/2)))
+		draw.DrawMask(dst, blurred.Rect.Add(offset), image.NewUniform(parseColor(shadow.color)), image.Point{}, blurred, blurred.Rect.Min, draw.Over)
+	}
+	if strokeWidth > 0 {
+		strokeColor := parseColor(getString(data, "stroke_color", defaultLineColor))
+		draw.DrawMask(dst, mask.Rect, image.NewUniform(strokeColor), image.Point{}, mask, mask.Rect.Min, draw.Over)
+	}
+	return nil
+}
+
+// textMask — альфа-маска строк текста (с подчёркиванием и зачёркиванием) в координатах холста внутри area
+func textMask(layout *textLayout, area image.Rectangle) *image.Alpha {
+	dc := gg.NewContext(area.Dx(), area.Dy())
+	dc.Translate(-float64(area.Min.X), -float64(area.Min.Y))
+	dc.SetRGB(1, 1, 1)
+	layout.draw(dc)
+
+	rgba := dc.Image().(*image.RGBA)
+	mask := image.NewAlpha(area)
+	for i := range mask.Pix {
+		mask.Pix[i] = rgba.Pix[i*4+3]
+	}
+	return mask
+}
+
+// dilateMask — маска, расширенная на radius пикселей (обводка букв)
+// Расстояние до ближайшей буквы считается точно (евклидово преобразование расстояний),
+// поэтому толстая обводка повторяет форму букв, а край остаётся сглаженным.
+func dilateMask(mask *image.Alpha, radius float64) *image.Alpha {
+	w, h := mask.Rect.Dx(), mask.Rect.Dy()
+	const inf = 1e20
+	dist := make([]float64, w*h)
+	for i, a := range mask.Pix {
+		if a >= 128 {
+			dist[i] = 0
+		} else {
+			dist[i] = inf
+		}
+	}
+
+	// Два прохода одномерного преобразования: по столбцам, затем по строкам
+	n := max(w, h)
+	f, d := make([]float64, n), make([]float64, n)
+	v, z := make([]int, n), make([]float64, n+1)
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			f[y] = dist[y*w+x]
+		}
+		distanceTransform1D(f[:h], d[:h], v, z)
+		for y := 0; y < h; y++ {
+			dist[y*w+x] = d[y]
+		}
+	}
+	for y := 0; y < h; y++ {
+		copy(f[:w], dist[y*w:(y+1)*w])
+		distanceTransform1D(f[:w], d[:w], v, z)
+		copy(dist[y*w:(y+1)*w], d[:w])
+	}
+
+	out := image.NewAlpha(mask.Rect)
+	for i, sq := range dist {
+		coverage := max(0, min(radius+0.5-math.Sqrt(sq), 1))
+		out.Pix[i] = max(mask.Pix[i], uint8(coverage*255+0.5))
+	}
+	return out
+}
+
+// distanceTransform1D — квадраты расстояний до ближайшей точки с f == 0 (алгоритм Фельценшвальба — Хуттенлохера)
+// v и z — рабочие буферы длиной не меньше len(f) и len(f)+1.
+func distanceTransform1D(f, d []float64, v []int, z []float64) {
+	k := 0
+	v[0] = 0
+	z[0], z[1] = math.Inf(-1), math.Inf(1)
+	for q := 1; q < len(f); q++ {
+		s := ((f[q] + float64(q*q)) - (f[v[k]] + float64(v[k]*v[k]))) / float64(2*q-2*v[k])
+		for s <= z[k] {
+			k--
+			s = ((f[q] + float64(q*q)) - (f[v[k]] + float64(v[k]*v[k]))) / float64(2*q-2*v[k])
+		}
+		k++
+		v[k] = q
+		z[k], z[k+1] = s, math.Inf(1)
+	}
+	k = 0
+	for q := range f {
+		for z[k+1] < float64(q) {
+			k++
+		}
+		d[q] = float64((q-v[k])*(q-v[k])) + f[v[k]]
+	}
+}
+
+// blurMask — размытие маски тремя проходами box blur радиуса radius (близко к гауссову с σ ≈ radius)
+func blurMask(mask *image.Alpha, radius int) *image.Alpha {
+	if radius <= 0 {
+		return mask
+	}
+	w, h := mask.Rect.Dx(), mask.Rect.Dy()
+	buf := make([]float64, w*h)
+	for i, a := range mask.Pix {
+		buf[i] = float64(a)
+	}
+	line := make([]float64, max(w, h))
+	for pass := 0; pass < 3; pass++ {
+		for y := 0; y < h; y++ {
+			boxBlur1D(buf[y*w:(y+1)*w], line[:w], 1, radius)
+		}
+		for x := 0; x < w; x++ {
+			boxBlur1D(buf[x:], line[:h], w, radius)
+		}
+	}
+
+	out := image.NewAlpha(mask.Rect)
+	for i, a := range buf {
+		out.Pix[i] = uint8(max(0, min(a, 255)) + 0.5)
+	}
+	return out
+}
+
+// boxBlur1D — среднее по окну 2·radius+1 вдоль одной строки или столбца (шаг stride); за краем — нули
+func boxBlur1D(values, line []float64, stride, radius int) {
+	n := len(line)
+	for i := range line {
+		line[i] = values[i*stride]
+	}
+	var sum float64
+	for i := 0; i < min(radius, n); i++ {
+		sum += line[i]
+	}
+	window := float64(2*radius + 1)
+	for i := 0; i < n; i++ {
+		if j := i + radius; j < n {
+			sum += line[j]
+		}
+		if j := i - radius - 1; j >= 0 {
+			sum -= line[j]
+		}
+		values[i*stride] = sum / window
+	}
+}
@@ -0,0 +1,292 @@
+// textlayer.go
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/font"
+)
+
+const (
+	defaultLineSpacing = 1.2   // Межстрочный интервал (доля высоты строки шрифта)
+	defaultMinFontSize = 12.0  // Меньше этого fit: shrink шрифт не уменьшает
+	shrinkStep         = 0.95  // Во сколько раз уменьшать шрифт на каждом шаге fit: shrink
+	maxLetterSpacing   = 200.0 // Предел letter_spacing в пикселях (в обе стороны)
+)
+
+// textLayout — разметка текстового слоя: строки и их положение на холсте
+type textLayout struct {
+	face       font.Face
+	fontSize   float64
+	lines      []string
+	widths     []float64
+	xs         []float64 // Левый край каждой строки
+	baselines  []float64 // Базовая линия каждой строки
+	ascent     float64
+	descent    float64
+	fontHeight float64 // Высота строки шрифта
+	lineHeight float64 // Шаг между базовыми линиями (fontHeight × line_spacing)
+	xHeight    float64 // Высота строчных букв — для text_decoration: strikethrough
+	spacing    float64 // letter_spacing: добавка к расстоянию между символами
+
+	underline     bool
+	strikethrough bool
+}
+
+// drawText — рисует текстовый слой шрифтом font_family / font_weight размера font_size (см. fonts.go)
+// С шириной width (или w) текст выводится в рамке x, y, width, height: переносится по словам (fit: wrap, по умолчанию)
+// или ещё и уменьшается, пока не поместится (fit: shrink). Без ширины строки (\n) выводятся от точки x, y.
+// Под текстом — подложка background и тень shadow, вокруг букв — обводка stroke_color/stroke_width (см. texteffects.go).
+func drawText(dc *gg.Context, data map[string]interface{}, faces faceCache) error {
+	text := getString(data, "text", "")
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+
+	layout, err := layoutText(text, data, faces)
+	if err != nil {
+		return err
+	}
+
+	drawTextBackground(dc, layout, data)
+	if err := drawTextEffects(dc, layout, data); err != nil {
+		return err
+	}
+	dc.SetColor(parseColor(getString(data, "color", "#000000")))
+	layout.draw(dc)
+	return nil
+}
+
+// layoutText — переносы, подбор размера шрифта и положение строк
+func layoutText(text string, data map[string]interface{}, faces faceCache) (*textLayout, error) {
+	x, y, w, h := layerBox(data, 0, 0)
+	fontSize := max(1, min(getFloat(data, "font_size", defaultFontSize), maxFontSize))
+	minFontSize := max(1, min(getFloat(data, "min_font_size", defaultMinFontSize), fontSize))
+	lineSpacing := getFloat(data, "line_spacing", defaultLineSpacing)
+	if lineSpacing <= 0 {
+		lineSpacing = defaultLineSpacing
+	}
+	family := getString(data, "font_family", config.Fonts.DefaultFamily)
+	weight := layerFontWeight(data)
+	letterSpacing := max(-maxLetterSpacing, min(getFloat(data, "letter_spacing", 0), maxLetterSpacing))
+	fit := getString(data, "fit", "wrap")
+	if w <= 0 {
+		fit = "none"
+	}
+	switch getString(data, "text_transform", "none") {
+	case "uppercase":
+		text = strings.ToUpper(text)
+	case "lowercase":
+		text = strings.ToLower(text)
+	}
+
+	paragraphs := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var layout *textLayout
+	for size := fontSize; ; size = max(minFontSize, size*shrinkStep) {
+		face, err := faces.face(family, weight, size)
+		if err != nil {
+			return nil, err
+		}
+		layout = newTextLayout(face, size, lineSpacing, letterSpacing)
+		brokeWords := false
+		for _, paragraph := range paragraphs {
+			if fit == "none" {
+				layout.lines = append(layout.lines, paragraph)
+				continue
+			}
+			lines, broke := layout.wrapParagraph(paragraph, w)
+			layout.lines = append(layout.lines, lines...)
+			brokeWords = brokeWords || broke
+		}
+		fits := !brokeWords && (h <= 0 || layout.height() <= h)
+		if fit != "shrink" || fits || size <= minFontSize {
+			break
+		}
+	}
+
+	for _, line := range layout.lines {
+		layout.widths = append(layout.widths, layout.measure(line))
+	}
+	layout.place(x, y, w, h, getString(data, "align", "left"), getString(data, "valign", "top"))
+	layout.underline, layout.strikethrough = textDecorations(getString(data, "text_decoration", "none"))
+	return layout, nil
+}
+
+// newTextLayout — пустая разметка для шрифта
+func newTextLayout(face font.Face, fontSize, lineSpacing, letterSpacing float64) *textLayout {
+	metrics := face.Metrics()
+	xHeight := float64(metrics.XHeight) / 64
+	if xHeight <= 0 {
+		xHeight = float64(metrics.Ascent) / 64 / 2
+	}
+	return &textLayout{
+		face:       face,
+		fontSize:   fontSize,
+		ascent:     float64(metrics.Ascent) / 64,
+		descent:    float64(metrics.Descent) / 64,
+		fontHeight: float64(metrics.Height) / 64,
+		lineHeight: float64(metrics.Height) / 64 * lineSpacing,
+		xHeight:    xHeight,
+		spacing:    letterSpacing,
+	}
+}
+
+// textDecorations — линии text_decoration: underline, strikethrough или оба через пробел
+func textDecorations(decoration string) (underline, strikethrough bool) {
+	for _, d := range strings.Fields(decoration) {
+		switch d {
+		case "underline":
+			underline = true
+		case "strikethrough", "line-through":
+			strikethrough = true
+		}
+	}
+	return underline, strikethrough
+}
+
+// measure — ширина строки с учётом letter_spacing
+func (l *textLayout) measure(s string) float64 {
+	width := measureText(l.face, s)
+	if n := utf8.RuneCountInString(s); n > 1 {
+		width += l.spacing * float64(n-1)
+	}
+	return width
+}
+
+// draw — выводит строки текущим цветом dc вместе с подчёркиванием и зачёркиванием
+func (l *textLayout) draw(dc *gg.Context) {
+	dc.SetFontFace(l.face)
+	for i, line := range l.lines {
+		x, baseline := l.xs[i], l.baselines[i]
+		if l.spacing == 0 {
+			dc.DrawString(line, x, baseline)
+		} else {
+			// По символу: отступ каждого — ширина начала строки (с кернингом) плюс letter_spacing
+			runes := []rune(line)
+			for j, r := range runes {
+				dc.DrawString(string(r), x+measureText(l.face, string(runes[:j]))+l.spacing*float64(j), baseline)
+			}
+		}
+		if l.widths[i] <= 0 {
+			continue
+		}
+		thickness := max(1, l.fontSize/16)
+		if l.underline {
+			dc.DrawRectangle(x, baseline+thickness, l.widths[i], thickness)
+		}
+		if l.strikethrough {
+			dc.DrawRectangle(x, baseline-l.xHeight/2-thickness/2, l.widths[i], thickness)
+		}
+		dc.Fill()
+	}
+}
+
+// bounds — прямоугольник, который занимают строки (left, top, right, bottom)
+func (l *textLayout) bounds() (left, top, right, bottom float64) {
+	if len(l.lines) == 0 {
+		return 0, 0, 0, 0
+	}
+	left, right = l.xs[0], l.xs[0]+l.widths[0]
+	for i, x := range l.xs {
+		left = min(left, x)
+		right = max(right, x+l.widths[i])
+	}
+	top = l.baselines[0] - l.ascent
+	bottom = l.baselines[len(l.baselines)-1] + l.descent
+	return left, top, right, bottom
+}
+
+// height — высота блока текста от верха первой строки до низа последней
+func (l *textLayout) height() float64 {
+	if len(l.lines) == 0 {
+		return 0
+	}
+	return l.ascent + l.descent + float64(len(l.lines)-1)*l.lineHeight
+}
+
+// place — положение строк
+// В рамке (w > 0) align выравнивает строки по ширине рамки, а valign (top, middle, bottom) — блок по высоте h.
+// Без рамки x, y — точка привязки, как раньше: для left y — базовая линия первой строки,
+// для center и right — середина первой строки.
+func (l *textLayout) place(x, y, w, h float64, align, valign string) {
+	var top float64
+	switch {
+	case w > 0 && h > 0 && valign == "middle":
+		top = y + (h-l.height())/2
+	case w > 0 && h > 0 && valign == "bottom":
+		top = y + h - l.height()
+	case w > 0:
+		top = y
+	case align == "center" || align == "right":
+		top = y + l.fontHeight/2 - l.ascent // Как gg.DrawStringAnchored(..., 0.5, 0.5)
+	default:
+		top = y - l.ascent
+	}
+
+	for i, width := range l.widths {
+		var lineX float64
+		switch {
+		case align == "center" && w > 0:
+			lineX = x + (w-width)/2
+		case align == "right" && w > 0:
+			lineX = x + w - width
+		case align == "center":
+			lineX = x - width/2
+		case align == "right":
+			lineX = x - width
+		default:
+			lineX = x
+		}
+		l.xs = append(l.xs, lineX)
+		l.baselines = append(l.baselines, top+l.ascent+float64(i)*l.lineHeight)
+	}
+}
+
+// wrapParagraph — перенос абзаца по словам в ширину width
+// Слово длиннее строки разбивается по символам (broke == true — для fit: shrink это значит «не поместилось»).
+func (l *textLayout) wrapParagraph(paragraph string, width float64) (lines []string, broke bool) {
+	words := strings.FieldsFunc(paragraph, unicode.IsSpace)
+	if len(words) == 0 {
+		return []string{""}, false
+	}
+	line := ""
+	for _, word := range words {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if l.measure(candidate) <= width {
+			line = candidate
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		line = word
+		for l.measure(line) > width {
+			head, tail := l.splitToWidth(line, width)
+			lines = append(lines, head)
+			line = tail
+			broke = true
+		}
+	}
+	return append(lines, line), broke
+}
+
+// splitToWidth — начало слова, которое помещается в width (хотя бы один символ), и остаток
+func (l *textLayout) splitToWidth(word string, width float64) (head, tail string) {
+	runes := []rune(word)
+	n := 1
+	for n < len(runes) && l.measure(string(runes[:n+1])) <= width {
+		n++
+	}
+	return string(runes[:n]), string(runes[n:])
+}
+
+// measureText — ширина строки в пикселях
+func measureText(face font.Face, s string) float64 {
+	return float64(font.MeasureString(face, s)) / 64
+}
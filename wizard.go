@@ -0,0 +1,203 @@
+// wizard.go
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// WizardOption — вариант ответа на шаге мастера (inline-кнопка)
+type WizardOption struct {
+	Label    string // Текст кнопки
+	Callback string // Callback data кнопки
+	Value    string // Значение, которое сохраняется в TempData
+}
+
+// WizardStep — шаг мастера
+type WizardStep struct {
+	Key      string                             // Ключ в TempData, куда сохраняется ответ (и имя состояния <wizard>_<key>)
+	Prompt   string                             // Текст вопроса
+	Options  []WizardOption                     // Варианты ответа (inline-клавиатура), необязательно
+	Columns  int                                // Кнопок в строке (по умолчанию 2)
+	FreeText bool                               // Можно ли ответить текстом, если есть варианты
+	Validate func(input string) (string, error) // Проверка и нормализация текстового ответа
+}
+
+// Wizard — пошаговый сценарий: шаги по порядку, ответы копятся в TempData, в конце вызывается Finish
+type Wizard struct {
+	ID     string
+	Steps  []WizardStep
+	Start  func(state *UserState)                                            // Подготовка TempData перед первым шагом (необязательно)
+	Finish func(ctx context.Context, state *UserState, bot *tgbotapi.BotAPI) // Завершение: ответы лежат в state.TempData
+}
+
+const (
+	wizardKeepCallback = "wz_keep" // Оставить текущее значение (при редактировании уже заполненных данных)
+	wizardBackCallback = "wz_back" // Вернуться на предыдущий шаг
+	cancelCallback     = "cancel"  // Прервать любой сценарий и вернуться в главное меню
+)
+
+var (
+	wizards     = make(map[string]*Wizard)    // ID мастера → мастер
+	wizardSteps = make(map[string]wizardStep) // Имя состояния → шаг мастера
+)
+
+type wizardStep struct {
+	wizard *Wizard
+	index  int
+}
+
+// registerWizard — регистрирует мастер; состояния его шагов называются <ID>_<Key>
+func registerWizard(w *Wizard) {
+	wizards[w.ID] = w
+	for i := range w.Steps {
+		wizardSteps[w.stateName(i)] = wizardStep{wizard: w, index: i}
+	}
+}
+
+// stateName — имя состояния пользователя для шага
+func (w *Wizard) stateName(index int) string {
+	return w.ID + "_" + w.Steps[index].Key
+}
+
+// currentWizardStep — шаг мастера, на котором находится пользователь
+func currentWizardStep(state *UserState) (*Wizard, int, bool) {
+	ws, ok := wizardSteps[state.State]
+	if !ok {
+		return nil, 0, false
+	}
+	return ws.wizard, ws.index, true
+}
+
+// StartWizard — запускает мастер с первого шага
+func StartWizard(id string, state *UserState, bot *tgbotapi.BotAPI) {
+	w, ok := wizards[id]
+	if !ok {
+		slog.Error("Unknown wizard", "wizard", id)
+		return
+	}
+	state.TempData = make(map[string]string)
+	if w.Start != nil {
+		w.Start(state)
+	}
+	w.goTo(0, state, bot)
+}
+
+// goTo — переводит пользователя на шаг и задаёт вопрос
+func (w *Wizard) goTo(index int, state *UserState, bot *tgbotapi.BotAPI) {
+	state.State = w.stateName(index)
+	SaveUserState(state)
+
+	step := w.Steps[index]
+	text := step.Prompt
+	current := state.TempData[step.Key]
+	if current != "" {
+		text += "\n\n✏️ Сейчас: " + w.optionLabel(step, current)
+	}
+
+	msg := tgbotapi.NewMessage(state.ChatID, text)
+	msg.ReplyMarkup = WizardStepInline(step, current != "", index > 0)
+	bot.Send(msg)
+}
+
+// optionLabel — подпись варианта по значению (для показа текущего ответа)
+func (w *Wizard) optionLabel(step WizardStep, value string) string {
+	for _, option := range step.Options {
+		if option.Value == value {
+			return option.Label
+		}
+	}
+	return value
+}
+
+// answer — сохраняет ответ и переходит к следующему шагу или завершает мастер
+func (w *Wizard) answer(ctx context.Context, index int, value string, state *UserState, bot *tgbotapi.BotAPI) {
+	state.TempData[w.Steps[index].Key] = value
+	if index+1 < len(w.Steps) {
+		w.goTo(index+1, state, bot)
+		return
+	}
+	w.Finish(ctx, state, bot)
+}
+
+// handleWizardInput — текстовый ответ на шаге мастера; false, если пользователь не в мастере
+func handleWizardInput(ctx context.Context, state *UserState, input string, bot *tgbotapi.BotAPI) bool {
+	w, index, ok := currentWizardStep(state)
+	if !ok {
+		return false
+	}
+
+	step := w.Steps[index]
+	if len(step.Options) > 0 && !step.FreeText {
+		bot.Send(tgbotapi.NewMessage(state.ChatID, "👆 Выбери один из вариантов кнопками выше."))
+		return true
+	}
+
+	value := strings.TrimSpace(input)
+	if step.Validate != nil {
+		var err error
+		if value, err = step.Validate(value); err != nil {
+			bot.Send(tgbotapi.NewMessage(state.ChatID, "❌ "+err.Error()))
+			return true
+		}
+	}
+	w.answer(ctx, index, value, state, bot)
+	return true
+}
+
+// handleWizardCallback — нажатие кнопки на шаге мастера; false, если кнопка не относится к текущему шагу
+func handleWizardCallback(ctx context.Context, state *UserState, data string, bot *tgbotapi.BotAPI) bool {
+	w, index, ok := currentWizardStep(state)
+	if !ok {
+		return false
+	}
+
+	step := w.Steps[index]
+	if data == wizardBackCallback {
+		// Предыдущий ответ остаётся в TempData — шаг покажет его и предложит оставить или исправить
+		if index > 0 {
+			index--
+		}
+		w.goTo(index, state, bot)
+		return true
+	}
+	if data == wizardKeepCallback && state.TempData[step.Key] != "" {
+		w.answer(ctx, index, state.TempData[step.Key], state, bot)
+		return true
+	}
+	for _, option := range step.Options {
+		if option.Callback == data {
+			w.answer(ctx, index, option.Value, state, bot)
+			return true
+		}
+	}
+	return false
+}
+
+// CancelFlow — прерывает текущий сценарий и возвращает пользователя в главное меню
+func CancelFlow(state *UserState, bot *tgbotapi.BotAPI) {
+	chatID := state.ChatID
+	text := "✖️ Действие отменено."
+	if state.State == "idle" {
+		text = "Отменять нечего — ты в главном меню."
+	}
+	ResetUserState(chatID)
+
+	msg := tgbotapi.NewMessage(chatID, text+"\n\nВыбери функцию из меню:")
+	msg.ReplyMarkup = MainMenu(ActiveProfileTitle(chatID))
+	bot.Send(msg)
+}
+
+// requireText — валидатор: ответ не должен быть пустым
+func requireText(message string) func(string) (string, error) {
+	return func(input string) (string, error) {
+		if input == "" {
+			return "", errors.New(message)
+		}
+		return input, nil
+	}
+}
@@ -0,0 +1,301 @@
+// workspaces.go
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Роли участников рабочего пространства
+const (
+	roleOwner  = "owner"
+	roleEditor = "editor"
+	roleViewer = "viewer"
+)
+
+const (
+	inviteTTL         = 7 * 24 * time.Hour // Срок действия приглашения
+	maxSharedPosts    = 20                 // Сколько последних постов команды хранить
+	maxSharedChannels = 10                 // Сколько каналов для публикации запоминать
+)
+
+var (
+	errNotOwner       = errors.New("это может сделать только владелец профиля")
+	errInviteNotFound = errors.New("приглашение не найдено или уже использовано")
+	errInviteExpired  = errors.New("срок действия приглашения истёк")
+	errUnknownRole    = errors.New("неизвестная роль")
+)
+
+// roleTitle — название роли для пользователя
+func roleTitle(role string) string {
+	switch role {
+	case roleOwner:
+		return "владелец"
+	case roleEditor:
+		return "редактор"
+	case roleViewer:
+		return "наблюдатель"
+	}
+	return role
+}
+
+// canEdit — может ли роль менять данные НКО, генерировать и публиковать посты
+func canEdit(role string) bool {
+	return role == roleOwner || role == roleEditor
+}
+
+// newInviteToken — случайный токен приглашения (подходит для параметра deep-link /start)
+func newInviteToken() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// normalizeWorkspaces — проставляет владельца и участников профилям, созданным до появления команд
+// Владельцем считается первый пользователь, у которого профиль есть в списке.
+func normalizeWorkspaces(db *NKODatabase) {
+	for chatID, user := range db.Users {
+		for _, id := range user.Profiles {
+			profile, ok := db.Profiles[id]
+			if !ok {
+				continue
+			}
+			if profile.Members == nil {
+				profile.Members = make(map[int64]WorkspaceMember)
+			}
+			if profile.Owner == 0 {
+				profile.Owner = chatID
+			}
+			if _, ok := profile.Members[chatID]; !ok {
+				role := roleEditor
+				if profile.Owner == chatID {
+					role = roleOwner
+				}
+				profile.Members[chatID] = WorkspaceMember{Role: role}
+			}
+		}
+	}
+}
+
+// ActiveRole — роль пользователя в активном профиле
+// Без профиля пользователь работает сам по себе и ограничений нет.
+func ActiveRole(chatID int64) string {
+	nkoDataMu.Lock()
+	defer nkoDataMu.Unlock()
+
+	profile := activeProfileLocked(chatID)
+	if profile == nil {
+		return roleOwner
+	}
+	return profile.Members[chatID].Role
+}
+
+// ActiveWorkspace — копия активного профиля вместе с участниками
+// Копия полная: задачи в фоне меняют участников, посты и каналы профиля, пока вызывающий их перебирает.
+func ActiveWorkspace(chatID int64) (NKOProfile, bool) {
+	nkoDataMu.Lock()
+	defer nkoDataMu.Unlock()
+
+	profile := activeProfileLocked(chatID)
+	if profile == nil {
+		return NKOProfile{}, false
+	}
+	return profile.clone(), true
+}
+
+// clone — копия профиля, не разделяющая с ним карты и срезы (вызывать под nkoDataMu)
+func (p *NKOProfile) clone() NKOProfile {
+	c := *p
+	c.Members = maps.Clone(p.Members)
+	c.Invites = maps.Clone(p.Invites)
+	c.Posts = slices.Clone(p.Posts)
+	c.Channels = slices.Clone(p.Channels)
+	return c
+}
+
+// CreateInvite — создать приглашение в активный профиль (только владелец)
+func CreateInvite(chatID int64, role string, ownerName string) (string, error) {
+	if role != roleEditor && role != roleViewer {
+		return "", errUnknownRole
+	}
+
+	nkoDataMu.Lock()
+	defer nkoDataMu.Unlock()
+
+	profile := activeProfileLocked(chatID)
+	if profile == nil {
+		return "", errProfileNotFound
+	}
+	if profile.Owner != chatID {
+		return "", errNotOwner
+	}
+
+	// Заодно запоминаем имя владельца для списка участников
+	if owner := profile.Members[chatID]; owner.Name == "" && ownerName != "" {
+		owner.Name = ownerName
+		profile.Members[chatID] = owner
+	}
+
+	// Убираем просроченные приглашения
+	if profile.Invites == nil {
+		profile.Invites = make(map[string]WorkspaceInvite)
+	}
+	for token, invite := range profile.Invites {
+		if time.Now().After(invite.ExpiresAt) {
+			delete(profile.Invites, token)
+		}
+	}
+
+	token := newInviteToken()
+	profile.Invites[token] = WorkspaceInvite{
+		Role:      role,
+		CreatedBy: chatID,
+		ExpiresAt: time.Now().Add(inviteTTL),
+	}
+	return token, persistNKODataLocked()
+}
+
+// AcceptInvite — принять приглашение: профиль добавляется пользователю и становится активным
+func AcceptInvite(chatID int64, token string, name string) (NKOProfile, string, error) {
+	nkoDataMu.Lock()
+	defer nkoDataMu.Unlock()
+
+	var profile *NKOProfile
+	var invite WorkspaceInvite
+	for _, p := range nkoDB.Profiles {
+		if inv, ok := p.Invites[token]; ok {
+			profile, invite = p, inv
+			break
+		}
+	}
+	if profile == nil {
+		return NKOProfile{}, "", errInviteNotFound
+	}
+	if time.Now().After(invite.ExpiresAt) {
+		delete(profile.Invites, token)
+		persistNKODataLocked()
+		return NKOProfile{}, "", errInviteExpired
+	}
+
+	user, ok := nkoDB.Users[chatID]
+	if !ok {
+		user = &UserProfiles{}
+		nkoDB.Users[chatID] = user
+	}
+
+	// Уже участник — просто переключаемся на профиль, роль не понижаем
+	if member, ok := profile.Members[chatID]; ok {
+		user.Active = profile.ID
+		return profile.clone(), member.Role, persistNKODataLocked()
+	}
+	if len(user.Profiles) >= maxProfilesPerUser {
+		return NKOProfile{}, "", errTooManyProfiles
+	}
+
+	delete(profile.Invites, token)
+	profile.Members[chatID] = WorkspaceMember{Role: invite.Role, Name: name, JoinedAt: time.Now()}
+	user.Profiles = append(user.Profiles, profile.ID)
+	user.Active = profile.ID
+	return profile.clone(), invite.Role, persistNKODataLocked()
+}
+
+// RemoveMember — исключить участника из активного профиля (только владелец)
+func RemoveMember(chatID int64, memberID int64) error {
+	nkoDataMu.Lock()
+	defer nkoDataMu.Unlock()
+
+	profile := activeProfileLocked(chatID)
+	if profile == nil {
+		return errProfileNotFound
+	}
+	if profile.Owner != chatID || memberID == chatID {
+		return errNotOwner
+	}
+	if _, ok := profile.Members[memberID]; !ok {
+		return errProfileNotFound
+	}
+	detachProfileLocked(memberID, profile.ID)
+	return persistNKODataLocked()
+}
+
+// detachProfileLocked — убрать профиль из списка пользователя и из участников профиля
+func detachProfileLocked(chatID int64, profileID string) {
+	if profile, ok := nkoDB.Profiles[profileID]; ok {
+		delete(profile.Members, chatID)
+	}
+	user, ok := nkoDB.Users[chatID]
+	if !ok {
+		return
+	}
+	remaining := make([]string, 0, len(user.Profiles))
+	for _, id := range user.Profiles {
+		if id != profileID {
+			remaining = append(remaining, id)
+		}
+	}
+	user.Profiles = remaining
+	if user.Active == profileID {
+		user.Active = ""
+		if len(remaining) > 0 {
+			user.Active = remaining[0]
+		}
+	}
+}
+
+// RecordPost — добавить пост в общую ленту активного профиля
+func RecordPost(chatID int64, post SharedPost) error {
+	nkoDataMu.Lock()
+	defer nkoDataMu.Unlock()
+
+	profile := activeProfileLocked(chatID)
+	if profile == nil || post.PostID == "" {
+		return nil
+	}
+	profile.Posts = append(profile.Posts, post)
+	if len(profile.Posts) > maxSharedPosts {
+		profile.Posts = profile.Posts[len(profile.Posts)-maxSharedPosts:]
+	}
+	return persistNKODataLocked()
+}
+
+// FindSharedPost — найти пост в общей ленте активного профиля
+func FindSharedPost(chatID int64, postID string) (SharedPost, bool) {
+	nkoDataMu.Lock()
+	defer nkoDataMu.Unlock()
+
+	profile := activeProfileLocked(chatID)
+	if profile == nil {
+		return SharedPost{}, false
+	}
+	for _, post := range profile.Posts {
+		if post.PostID == postID {
+			return post, true
+		}
+	}
+	return SharedPost{}, false
+}
+
+// RecordChannel — запомнить чат, куда команда опубликовала пост
+func RecordChannel(chatID int64, channel string) error {
+	nkoDataMu.Lock()
+	defer nkoDataMu.Unlock()
+
+	profile := activeProfileLocked(chatID)
+	if profile == nil || channel == "" {
+		return nil
+	}
+	for _, existing := range profile.Channels {
+		if existing == channel {
+			return nil
+		}
+	}
+	profile.Channels = append(profile.Channels, channel)
+	if len(profile.Channels) > maxSharedChannels {
+		profile.Channels = profile.Channels[len(profile.Channels)-maxSharedChannels:]
+	}
+	return persistNKODataLocked()
+}